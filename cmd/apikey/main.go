@@ -0,0 +1,80 @@
+// apikey issues and revokes API keys:
+//
+//	apikey -subject alice -roles admin -ttl 720h
+//	apikey -revoke <id>
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"testapp/pkg/config"
+	"testapp/pkg/http"
+	"testapp/pkg/pgsql"
+)
+
+const (
+	CONFIG_NAME      = "config"
+	CONFIG_EXTENSION = "yaml"
+	CONFIG_PATH      = "."
+)
+
+func main() {
+	subject := flag.String("subject", "", "who the key is issued to")
+	roles := flag.String("roles", "", "comma separated roles of the key")
+	ttl := flag.Duration("ttl", 0, "how long the key is valid, forever when 0")
+	revoke := flag.String("revoke", "", "id of a key to revoke")
+	flag.Parse()
+
+	if *subject == "" && *revoke == "" {
+		flag.Usage()
+		return
+	}
+
+	conf, err := config.LoadConfig(filepath.Join(".", "configs", CONFIG_NAME), CONFIG_EXTENSION, CONFIG_PATH)
+	if err != nil {
+		log.Fatalf("Error loading a config: %v", err)
+	}
+
+	db, err := pgsql.NewPgSQLConnection(conf.PgSQL)
+	if err != nil {
+		log.Fatalf("Error connecting to pgsql db: %v", err)
+	}
+
+	keys, err := http.NewAPIKeyStore(db)
+	if err != nil {
+		log.Fatalf("Error setting up API keys: %v", err)
+	}
+
+	ctx := context.Background()
+	if *revoke != "" {
+		if err := keys.Revoke(ctx, *revoke); err != nil {
+			log.Fatalf("Error revoking the key: %v", err)
+		}
+		return
+	}
+
+	var expiresAt *time.Time
+	if *ttl > 0 {
+		t := time.Now().Add(*ttl)
+		expiresAt = &t
+	}
+
+	var keyRoles []string
+	if *roles != "" {
+		keyRoles = strings.Split(*roles, ",")
+	}
+
+	key, err := keys.Create(ctx, *subject, keyRoles, expiresAt)
+	if err != nil {
+		log.Fatalf("Error creating the key: %v", err)
+	}
+
+	// the key can't be read back, only its hash is stored
+	fmt.Println(key)
+}
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"context"
 	"log"
 	"path/filepath"
+	"time"
 
-	"gorm.io/gorm"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"testapp/internal/handlers"
 	repPgSQL "testapp/internal/repositories/pgsql"
 	"testapp/internal/services"
 	"testapp/pkg/config"
+	"testapp/pkg/imaging"
 	"testapp/pkg/pgsql"
+	"testapp/pkg/storage"
+	"testapp/pkg/tracing"
 
 	// repsPgSQL "testapp/internal/repositories/pgsql"
 	"testapp/pkg/http"
@@ -20,6 +25,7 @@ const (
 	CONFIG_NAME = "config"
 	CONFIG_EXTENSION = "yaml" 
 	CONFIG_PATH = "."
+	TRACING_SHUTDOWN_TIMEOUT = 5 * time.Second
 )
 
 func main() {
@@ -29,6 +35,12 @@ func main() {
 		log.Fatalf("Error loading a config: %v", err)
 	}
 
+	// Tracing requests down to the queries they run
+	shutdownTracing, err := tracing.Setup(context.Background(), conf.Tracing)
+	if err != nil {
+		log.Fatalf("Error setting up tracing: %v", err)
+	}
+
 	// Connecting to database
 	db, err := pgsql.NewPgSQLConnection(conf.PgSQL)
 	if err != nil {
@@ -36,41 +48,103 @@ func main() {
 	}
 
 	log.Println("Database connected succesfully!")
-	
-	// Check database size
-	DatabaseSize, err := CheckDBSize(db)
+
+	// Timing queries, exporting the pool stats and the database size
+	if err := pgsql.Instrument(db, conf.PgSQL.DBName); err != nil {
+		log.Fatalf("Error instrumenting the database: %v", err)
+	}
+	if err := pgsql.Trace(db); err != nil {
+		log.Fatalf("Error tracing the database: %v", err)
+	}
+
+	// Setting up blob storage
+	store, err := storage.NewBlobStore(conf.Storage, db)
 	if err != nil {
-		log.Fatalf("Failed to check database size: %v", err)
+		log.Fatalf("Error creating a blob store: %v", err)
 	}
 
-	log.Printf("Database Size: %s\n", DatabaseSize)
+	// images stored in the database before are moved to the blob store
+	if err := repPgSQL.Migrate(db, store, services.BlobKey); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
 
 	imageRep := repPgSQL.NewImageRepository(db)
-	imageServ := services.NewImageService(imageRep)
-	imageHandler := handlers.NewImageHandler(imageServ)
+	fileRep := repPgSQL.NewFileRepository(db)
+	usageRep := repPgSQL.NewUsageRepository(db)
+	// images stored before usage was tracked are counted as well
+	if err := usageRep.Recount(context.Background()); err != nil {
+		log.Fatalf("Failed to count storage usage: %v", err)
+	}
+
+	imageServ, err := services.NewImageService(imageRep, fileRep, usageRep, store, conf.Imaging)
+	if err != nil {
+		log.Fatalf("Error creating the image service: %v", err)
+	}
+	// Setting up a cache for transformed images
+	cacheDir := conf.Imaging.CacheDir
+	if cacheDir == "" {
+		cacheDir = imaging.DEFAULT_CACHE_DIR
+	}
+
+	cache, err := storage.NewLocalStore(cacheDir)
+	if err != nil {
+		log.Fatalf("Error creating an image cache: %v", err)
+	}
+
+	transformServ := services.NewTransformService(imageServ, cache)
+	renditionServ := services.NewRenditionService(imageRep, imageServ, conf.Imaging.Renditions, conf.Imaging.Workers)
+	imageServ.OnSave(renditionServ.Enqueue)
+	imageServ.OnPurge(transformServ.Forget)
+	imageHandler := handlers.NewImageHandler(imageServ, transformServ, renditionServ, conf.HTTP)
 	formatHandler := handlers.NewFormatHandler()
 
-	// Creating new server and starting to listen
-	srv := http.NewServer(conf.HTTP, imageHandler, formatHandler)
-	
-	log.Printf("We are starting on %v", srv.Addr)
-	
-	if err := srv.ListenAndServe(); err != nil {
-		log.Fatal(err)
+	linkRep := repPgSQL.NewLinkRepository(db)
+	linkServ, err := services.NewLinkService(linkRep, imageServ, conf.Imaging)
+	if err != nil {
+		log.Fatalf("Error creating the link service: %v", err)
 	}
-}
+	linkHandler := handlers.NewLinkHandler(linkServ, imageHandler)
+	usageHandler := handlers.NewUsageHandler(imageServ)
+	prometheus.MustRegister(imageServ.Collector())
+	metricsHandler := handlers.NewMetricsHandler(conf.Metrics)
 
-type DBSize struct {
-	DatabaseSize string
-}
+	uploadRep := repPgSQL.NewUploadRepository(db)
+	uploadServ := services.NewUploadService(uploadRep, store, imageServ)
+	tusHandler := handlers.NewTusHandler(uploadServ)
+
+	auth, err := http.NewAuth(conf.HTTP.Auth, db)
+	if err != nil {
+		log.Fatalf("Error setting up authentication: %v", err)
+	}
 
-func CheckDBSize(db *gorm.DB) (DatabaseSize string, err error) {
-	var dbSize DBSize 
+	// Creating new server
+	srv := http.NewServer(conf.HTTP, auth, imageHandler, formatHandler, tusHandler, linkHandler, usageHandler, metricsHandler)
+	lifecycle := http.NewLifecycle(srv, conf.HTTP)
 
-	err = db.Raw("SELECT pg_size_pretty(pg_database_size(current_database())) AS database_size").Scan(&dbSize).Error
+	// Purging stale resumable uploads and the trash, generating renditions
+	lifecycle.Go("uploads", func(ctx context.Context) { uploadServ.Run(ctx, time.Hour) })
+	lifecycle.Go("trash", func(ctx context.Context) { imageServ.Run(ctx, time.Hour) })
+	lifecycle.Go("renditions", func(ctx context.Context) { renditionServ.Run(ctx, time.Minute) })
+
+	sqlDB, err := db.DB()
 	if err != nil {
-		return "", err
+		log.Fatalf("Error getting the database pool: %v", err)
+	}
+	// closed last, so spans of the requests drained are exported
+	lifecycle.OnStop("tracing", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), TRACING_SHUTDOWN_TIMEOUT)
+		defer cancel()
+
+		return shutdownTracing(ctx)
+	})
+	lifecycle.OnStop("database", sqlDB.Close)
+
+	log.Printf("We are starting on %v", srv.Addr)
+
+	// Serving until SIGINT or SIGTERM
+	if err := lifecycle.Run(context.Background()); err != nil {
+		log.Fatal(err)
 	}
 
-	return dbSize.DatabaseSize, nil
-}
\ No newline at end of file
+	log.Println("Server stopped")
+}
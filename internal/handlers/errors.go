@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"testapp/internal/repositories"
+	"testapp/internal/services"
+	"testapp/pkg/exif"
+	pkgHTTP "testapp/pkg/http"
+	"testapp/pkg/imaging"
+	"testapp/pkg/storage"
+)
+
+var (
+	errInvalidID      = pkgHTTP.InvalidInput("Error parsing the id", pkgHTTP.FieldError{Field: "id", Detail: "must be a UUID"})
+	errInvalidLinkID  = pkgHTTP.InvalidInput("Error parsing the link id", pkgHTTP.FieldError{Field: "link", Detail: "must be a UUID"})
+	errUploadNotFound = pkgHTTP.NotFound("Upload not found")
+)
+
+// problems are the domain errors clients can do something about, with the input field
+// they are about. Their messages are written for clients unless detail replaces them.
+var problems = []struct {
+	err    error
+	status int
+	code   string
+	field  string
+	detail string
+}{
+	{err: repositories.ErrNotFound, status: http.StatusNotFound, code: pkgHTTP.CODE_NOT_FOUND, detail: "Image not found"},
+	{err: storage.ErrNotFound, status: http.StatusNotFound, code: pkgHTTP.CODE_NOT_FOUND, detail: "File not found"},
+	{err: services.ErrGrantNotFound, status: http.StatusNotFound, code: pkgHTTP.CODE_NOT_FOUND},
+	{err: services.ErrInvalidLink, status: http.StatusNotFound, code: pkgHTTP.CODE_NOT_FOUND},
+	{err: services.ErrLinkNotFound, status: http.StatusNotFound, code: pkgHTTP.CODE_NOT_FOUND},
+
+	{err: services.ErrInvalidName, status: http.StatusBadRequest, code: pkgHTTP.CODE_INVALID_INPUT, field: "name"},
+	{err: services.ErrInvalidSort, status: http.StatusBadRequest, code: pkgHTTP.CODE_INVALID_INPUT, field: "sort"},
+	{err: services.ErrInvalidCursor, status: http.StatusBadRequest, code: pkgHTTP.CODE_INVALID_INPUT, field: "cursor"},
+	{err: services.ErrInvalidBatchMode, status: http.StatusBadRequest, code: pkgHTTP.CODE_INVALID_INPUT, field: "mode"},
+	{err: services.ErrInvalidVisibility, status: http.StatusBadRequest, code: pkgHTTP.CODE_INVALID_INPUT, field: "visibility"},
+	{err: services.ErrInvalidPermission, status: http.StatusBadRequest, code: pkgHTTP.CODE_INVALID_INPUT, field: "permission"},
+	{err: services.ErrInvalidSubject, status: http.StatusBadRequest, code: pkgHTTP.CODE_INVALID_INPUT, field: "subject"},
+	{err: services.ErrInvalidTTL, status: http.StatusBadRequest, code: pkgHTTP.CODE_INVALID_INPUT, field: "ttl"},
+	{err: services.ErrInvalidMaxDownloads, status: http.StatusBadRequest, code: pkgHTTP.CODE_INVALID_INPUT, field: "max_downloads"},
+	{err: services.ErrUnknownRendition, status: http.StatusBadRequest, code: pkgHTTP.CODE_INVALID_INPUT, field: "renditions"},
+	// invalid options as well, matched first as they are only too large for this image
+	{err: imaging.ErrOutputTooLarge, status: http.StatusUnprocessableEntity, code: pkgHTTP.CODE_TOO_LARGE},
+	{err: imaging.ErrInvalidOptions, status: http.StatusBadRequest, code: pkgHTTP.CODE_INVALID_INPUT},
+
+	{err: services.ErrUnsupportedType, status: http.StatusUnsupportedMediaType, code: pkgHTTP.CODE_UNSUPPORTED_TYPE},
+	{err: services.ErrTypeMismatch, status: http.StatusUnsupportedMediaType, code: pkgHTTP.CODE_UNSUPPORTED_TYPE},
+	{err: imaging.ErrUnsupportedFormat, status: http.StatusUnsupportedMediaType, code: pkgHTTP.CODE_UNSUPPORTED_TYPE, detail: "The image can't be transformed"},
+	// metadata of files that can't be followed could only be kept, so they aren't stored
+	{err: exif.ErrMalformed, status: http.StatusUnprocessableEntity, code: pkgHTTP.CODE_MALFORMED},
+	{err: imaging.ErrTooManyPixels, status: http.StatusUnprocessableEntity, code: pkgHTTP.CODE_TOO_LARGE, detail: "The image is too large to be transformed"},
+
+	{err: services.ErrFileExists, status: http.StatusConflict, code: pkgHTTP.CODE_CONFLICT},
+	{err: repositories.ErrConflict, status: http.StatusConflict, code: pkgHTTP.CODE_CONFLICT, detail: "The change conflicts with another one, try again"},
+	{err: services.ErrOffsetMismatch, status: http.StatusConflict, code: pkgHTTP.CODE_CONFLICT, detail: "Upload-Offset doesn't match"},
+	{err: services.ErrBatchAborted, status: http.StatusUnprocessableEntity, code: pkgHTTP.CODE_BATCH_ABORTED},
+
+	// 410 for what worked once, so clients know asking again won't help
+	{err: services.ErrUploadExpired, status: http.StatusGone, code: pkgHTTP.CODE_GONE, detail: "Upload expired"},
+	{err: services.ErrLinkExpired, status: http.StatusGone, code: pkgHTTP.CODE_GONE},
+	{err: services.ErrLinkRevoked, status: http.StatusGone, code: pkgHTTP.CODE_GONE},
+	{err: services.ErrLinkUsedUp, status: http.StatusGone, code: pkgHTTP.CODE_GONE},
+
+	// 404 is for images the principal can't see, 403 only for the ones it can see but doesn't own
+	{err: services.ErrPermissionDenied, status: http.StatusForbidden, code: pkgHTTP.CODE_FORBIDDEN},
+	{err: services.ErrRenditionNotAllowed, status: http.StatusForbidden, code: pkgHTTP.CODE_FORBIDDEN},
+
+	{err: storage.ErrTooLarge, status: http.StatusRequestEntityTooLarge, code: pkgHTTP.CODE_TOO_LARGE, detail: "File is too large"},
+	{err: services.ErrQuotaExceeded, status: http.StatusRequestEntityTooLarge, code: pkgHTTP.CODE_QUOTA_EXCEEDED},
+	{err: services.ErrStorageFull, status: http.StatusInsufficientStorage, code: pkgHTTP.CODE_INSUFFICIENT_STORAGE},
+	{err: repositories.ErrUnavailable, status: http.StatusServiceUnavailable, code: pkgHTTP.CODE_UNAVAILABLE, detail: "The database is unavailable, try again later"},
+}
+
+// writeError answers with the problem err stands for, errors clients can't fix
+// are described by explanation only
+func writeError(resp http.ResponseWriter, req *http.Request, err error, explanation string) {
+	pkgHTTP.WriteError(resp, req, problemOf(err, pkgHTTP.Internal(explanation, nil)))
+}
+
+// problemOf maps err to what clients are told, fallback is used for errors that aren't mapped
+func problemOf(err error, fallback *pkgHTTP.Error) *pkgHTTP.Error {
+	var problem *pkgHTTP.Error
+	if errors.As(err, &problem) {
+		return problem
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		detail := "Request body is too large, the limit is " + strconv.FormatInt(maxBytesErr.Limit, 10) + " bytes"
+		return &pkgHTTP.Error{Status: http.StatusRequestEntityTooLarge, Code: pkgHTTP.CODE_TOO_LARGE, Detail: detail, Err: err}
+	}
+
+	for _, p := range problems {
+		if !errors.Is(err, p.err) {
+			continue
+		}
+
+		problem := &pkgHTTP.Error{Status: p.status, Code: p.code, Detail: p.detail, Err: err}
+		if problem.Detail == "" {
+			problem.Detail = err.Error()
+		}
+		if p.field != "" {
+			problem.Fields = []pkgHTTP.FieldError{{Field: p.field, Detail: problem.Detail}}
+		}
+		return problem
+	}
+
+	fallback.Err = err
+	return fallback
+}
@@ -39,18 +39,18 @@ func NewFormatHandler() *FormatHandler {
 	return &FormatHandler{}
 }
 
-func (*FormatHandler) Register(mux *http.ServeMux) {
-	mux.HandleFunc(pkgHTTP.GetPath(FOO_PATH), func(resp http.ResponseWriter, req *http.Request) {
+func (*FormatHandler) Register(router *pkgHTTP.Router) {
+	router.HandleFunc(pkgHTTP.GetPath(FOO_PATH), func(resp http.ResponseWriter, req *http.Request) {
 		resp.Write([]byte("Hello, world!"))
-	})
+	}, pkgHTTP.Public)
 
-	mux.HandleFunc(pkgHTTP.GetPath(JSON_PATH), func(resp http.ResponseWriter, req *http.Request) {
+	router.HandleFunc(pkgHTTP.GetPath(JSON_PATH), func(resp http.ResponseWriter, req *http.Request) {
 		resp.Header().Set("Content-Type", "application/json; charset=utf-8")
 		resp.Write(jsonData)
-	})
+	}, pkgHTTP.Public)
 
-	mux.HandleFunc(pkgHTTP.GetPath(XML_PATH), func(resp http.ResponseWriter, req *http.Request) {
+	router.HandleFunc(pkgHTTP.GetPath(XML_PATH), func(resp http.ResponseWriter, req *http.Request) {
 		resp.Header().Set("Content-Type", "application/xml; charset=utf-8")
 		resp.Write(xmlData)
-	})
+	}, pkgHTTP.Public)
 }
\ No newline at end of file
@@ -1,141 +1,612 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
-	"gorm.io/gorm"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
 
+	"testapp/internal/models"
+	"testapp/internal/repositories"
 	"testapp/internal/services"
 	pkgHTTP "testapp/pkg/http"
+	"testapp/pkg/imaging"
 )
 
+var tracer = otel.Tracer("testapp/internal/handlers")
+
 const (
-	DOWNLOAD_PATH = "/download" 
+	DOWNLOAD_PATH = "/download/{name}"
 	UPLOAD_PATH = "/upload" 
 	SAVE_DB_PATH = "/savedb"
+	IMAGES_PATH = "/images"
+	IMAGE_PATH = "/images/{id}"
+	RESTORE_PATH = "/images/{id}/restore"
 	SHOW_PATH = "/show/{id}"
+	SHOW_RENDITION_PATH = "/show/{id}/{rendition}"
+	RENDITIONS_PATH = "/images/{id}/renditions"
+	META_PATH = "/images/{id}/meta"
+	GRANTS_PATH = "/images/{id}/grants"
+	GRANT_PATH = "/images/{id}/grants/{subject}"
 
-	FILENAME = "diagram.png"
-
-	MaxUploadSize = 10 << 20
+	MaxUploadSize = 512 << 20
+	MaxJSONSize = 1 << 20
+	MaxBatchSize = 1000
 )
 
 type ImageHandler struct {
-	serv *services.ImageService
+	serv       *services.ImageService
+	transforms *services.TransformService
+	renditions *services.RenditionService
+
+	cacheControl  string
+	maxUploadSize int64
+}
+
+func NewImageHandler(serv *services.ImageService, transforms *services.TransformService, renditions *services.RenditionService, conf pkgHTTP.Config) *ImageHandler {
+	cacheControl := conf.CacheControl
+	if cacheControl == "" {
+		cacheControl = pkgHTTP.DEFAULT_CACHE_CONTROL
+	}
+
+	return &ImageHandler{serv: serv, transforms: transforms, renditions: renditions, cacheControl: cacheControl,
+		maxUploadSize: maxUploadSize(serv.MaxSize())}
 }
 
-func NewImageHandler(serv *services.ImageService) *ImageHandler {
-	return &ImageHandler{serv: serv}
+// maxUploadSize is MaxUploadSize unless the store holds less
+func maxUploadSize(storeMax int64) int64 {
+	if storeMax > 0 && storeMax < MaxUploadSize {
+		return storeMax
+	}
+
+	return MaxUploadSize
 }
 
-func (h *ImageHandler) Register(mux *http.ServeMux) {
-	mux.HandleFunc(pkgHTTP.GetPath(DOWNLOAD_PATH), h.download)
-	mux.HandleFunc(pkgHTTP.PostPath(UPLOAD_PATH), h.upload)
-	mux.HandleFunc(pkgHTTP.PostPath(SAVE_DB_PATH), h.saveDB)
-	mux.HandleFunc(pkgHTTP.GetPath(SHOW_PATH), h.show)
+func (h *ImageHandler) Register(router *pkgHTTP.Router) {
+	// uploads are streamed, the other bodies are small JSON documents
+	uploads := router.With(pkgHTTP.BodyLimit(h.maxUploadSize))
+	documents := router.With(pkgHTTP.BodyLimit(MaxJSONSize))
+
+	router.HandleFunc(pkgHTTP.GetPath(DOWNLOAD_PATH), h.download)
+	uploads.HandleFunc(pkgHTTP.PostPath(UPLOAD_PATH), h.upload)
+	uploads.HandleFunc(pkgHTTP.PostPath(SAVE_DB_PATH), h.saveDB)
+	router.HandleFunc(pkgHTTP.GetPath(IMAGES_PATH), h.list)
+	documents.HandleFunc(pkgHTTP.DeletePath(IMAGES_PATH), h.deleteBatch)
+	router.HandleFunc(pkgHTTP.DeletePath(IMAGE_PATH), h.delete)
+	documents.HandleFunc(pkgHTTP.PatchPath(IMAGE_PATH), h.update)
+	router.HandleFunc(pkgHTTP.PostPath(RESTORE_PATH), h.restore)
+	router.HandleFunc(pkgHTTP.GetPath(SHOW_PATH), h.show)
+	router.HandleFunc(pkgHTTP.GetPath(SHOW_RENDITION_PATH), h.showRendition)
+	router.HandleFunc(pkgHTTP.GetPath(RENDITIONS_PATH), h.listRenditions)
+	router.HandleFunc(pkgHTTP.GetPath(META_PATH), h.meta)
+	router.HandleFunc(pkgHTTP.GetPath(GRANTS_PATH), h.listGrants)
+	documents.HandleFunc(pkgHTTP.PutPath(GRANT_PATH), h.grant)
+	router.HandleFunc(pkgHTTP.DeletePath(GRANT_PATH), h.revoke)
 }
 
+// download serves an image by its id or a file by the name it's stored under,
+// as an attachment unless ?disposition=inline is asked for
 func (h *ImageHandler) download(resp http.ResponseWriter, req *http.Request) {
-	fileBytes, err := h.serv.ReadFile(req.Context(), FILENAME)
+	disposition := req.URL.Query().Get("disposition")
+	switch disposition {
+	case "":
+		disposition = pkgHTTP.DISPOSITION_ATTACHMENT
+	case pkgHTTP.DISPOSITION_INLINE, pkgHTTP.DISPOSITION_ATTACHMENT:
+	default:
+		pkgHTTP.WriteError(resp, req, pkgHTTP.InvalidInput("Disposition must be inline or attachment", pkgHTTP.FieldError{Field: "disposition", Detail: "must be inline or attachment"}))
+		return
+	}
+
+	// the content is served as the declared type only
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+
+	name := req.PathValue("name")
+	if id, err := uuid.Parse(name); err == nil {
+		h.downloadImage(resp, req, id, disposition)
+		return
+	}
+
+	file, err := h.serv.OpenFile(req.Context(), name)
 	if err != nil {
-		pkgHTTP.WriteResponse(resp, http.StatusBadRequest, "Can't read the file", FILENAME)
+		writeError(resp, req, err, "Can't read the file")
 		return
 	}
-	resp.Header().Set("Content-Type", "image/png")
-	resp.Header().Set("Content-Disposition", "attachment; filename="+FILENAME)
-	resp.Write(fileBytes)
+	defer file.Close()
+
+	resp.Header().Set("Content-Disposition", pkgHTTP.ContentDisposition(disposition, file.Name))
+	h.serveContent(resp, req, file.ContentType, file.Digest, file.ModTime, false, file)
 }
 
-func (h *ImageHandler) upload(resp http.ResponseWriter, req *http.Request) {
-	// if file is too large
-	if req.ContentLength > MaxUploadSize {
-		pkgHTTP.WriteResponse(resp, http.StatusRequestEntityTooLarge, "File is too large")
+func (h *ImageHandler) downloadImage(resp http.ResponseWriter, req *http.Request, id uuid.UUID, disposition string) {
+	image, err := h.serv.Get(req.Context(), id)
+	if err != nil {
+		writeError(resp, req, err, "Error getting the image from db")
 		return
 	}
 
-	if err := req.ParseMultipartForm(MaxUploadSize); err != nil {
-		pkgHTTP.WriteResponse(resp, http.StatusBadRequest)
+	content, err := h.serv.Open(req.Context(), image)
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, pkgHTTP.Internal("Error reading the image from storage", err))
 		return
 	}
-	defer req.MultipartForm.RemoveAll()
+	defer content.Close()
 
-	h.saveFiles(resp, req)
+	resp.Header().Set("Content-Disposition", pkgHTTP.ContentDisposition(disposition, downloadName(image)))
+	h.serveContent(resp, req, image.ContentType, image.Digest, image.CreatedAt, public(image), content)
+}
+
+// downloadName is the uploaded file's name without directories,
+// images uploaded without one are named by their id
+func downloadName(image models.Image) string {
+	name := image.Filename[strings.LastIndexAny(image.Filename, `/\`)+1:]
+	if name != "" {
+		return name
+	}
+
+	name = image.ID.String()
+	if format := imaging.FormatOf(image.ContentType); format != "" {
+		name += "." + format
+	}
+
+	return name
+}
+
+func (h *ImageHandler) upload(resp http.ResponseWriter, req *http.Request) {
+	reader, ok := multipartReader(resp, req)
+	if !ok {
+		return
+	}
+
+	h.saveFiles(resp, req, reader)
 }
 
 func (h *ImageHandler) saveDB(resp http.ResponseWriter, req *http.Request) {
-	// if file is too large
-	if req.ContentLength > MaxUploadSize {
-		pkgHTTP.WriteResponse(resp, http.StatusRequestEntityTooLarge, "File is too large")
+	reader, ok := multipartReader(resp, req)
+	if !ok {
 		return
 	}
 
-	if err := req.ParseMultipartForm(MaxUploadSize); err != nil {
-		pkgHTTP.WriteResponse(resp, http.StatusBadRequest)
+	h.saveFilesToDB(resp, req, reader)
+}
+
+// list returns metadata of a page of images, the Link header points at the next page
+func (h *ImageHandler) list(resp http.ResponseWriter, req *http.Request) {
+	values := req.URL.Query()
+
+	query := services.ListQuery{Sort: values.Get("sort"), Cursor: values.Get("cursor")}
+	if limit := values.Get("limit"); limit != "" {
+		var err error
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 1 {
+			pkgHTTP.WriteError(resp, req, pkgHTTP.InvalidInput("Invalid limit", pkgHTTP.FieldError{Field: "limit", Detail: "must be a positive integer"}))
+			return
+		}
+	}
+	for _, contentType := range values["content_type"] {
+		query.ContentTypes = append(query.ContentTypes, strings.Split(contentType, ",")...)
+	}
+
+	images, next, err := h.serv.List(req.Context(), query)
+	if err != nil {
+		writeError(resp, req, err, "Error getting images from db")
 		return
 	}
-	defer req.MultipartForm.RemoveAll()
 
-	h.saveFilesToDB(resp, req)
+	if next != "" {
+		values.Set("cursor", next)
+		nextURL := url.URL{Path: req.URL.Path, RawQuery: values.Encode()}
+
+		resp.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL.String()))
+		resp.Header().Set("X-Next-Cursor", next)
+	}
+
+	if images == nil {
+		images = []models.Image{}
+	}
+
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(resp).Encode(images)
+}
+
+func (h *ImageHandler) delete(resp http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, errInvalidID)
+		return
+	}
+
+	deleted, err := h.serv.Delete(req.Context(), []uuid.UUID{id})
+	if err != nil {
+		writeError(resp, req, err, "Error deleting the image")
+		return
+	}
+
+	if len(deleted) == 0 {
+		pkgHTTP.WriteError(resp, req, pkgHTTP.NotFound("Image not found"))
+		return
+	}
+
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+type batchRequest struct {
+	IDs []uuid.UUID `json:"ids"`
+}
+
+type batchDeleteResponse struct {
+	Deleted  []uuid.UUID `json:"deleted"`
+	NotFound []uuid.UUID `json:"not_found"`
+}
+
+// deleteBatch moves the images listed in the JSON body to the trash
+func (h *ImageHandler) deleteBatch(resp http.ResponseWriter, req *http.Request) {
+	var batch batchRequest
+	if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
+		pkgHTTP.WriteError(resp, req, problemOf(err, pkgHTTP.InvalidInput("Error parsing the ids")))
+		return
+	}
+
+	if len(batch.IDs) == 0 || len(batch.IDs) > MaxBatchSize {
+		detail := fmt.Sprintf("Expected 1 to %d ids", MaxBatchSize)
+		pkgHTTP.WriteError(resp, req, pkgHTTP.InvalidInput(detail, pkgHTTP.FieldError{Field: "ids", Detail: detail}))
+		return
+	}
+
+	deleted, err := h.serv.Delete(req.Context(), batch.IDs)
+	if err != nil {
+		writeError(resp, req, err, "Error deleting the images")
+		return
+	}
+
+	result := batchDeleteResponse{Deleted: deleted, NotFound: []uuid.UUID{}}
+	if result.Deleted == nil {
+		result.Deleted = []uuid.UUID{}
+	}
+	for _, id := range batch.IDs {
+		if !slices.Contains(deleted, id) && !slices.Contains(result.NotFound, id) {
+			result.NotFound = append(result.NotFound, id)
+		}
+	}
+
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(resp).Encode(result)
+}
+
+func (h *ImageHandler) restore(resp http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, errInvalidID)
+		return
+	}
+
+	restored, err := h.serv.Restore(req.Context(), []uuid.UUID{id})
+	if err != nil {
+		writeError(resp, req, err, "Error restoring the image")
+		return
+	}
+
+	if len(restored) == 0 {
+		pkgHTTP.WriteError(resp, req, pkgHTTP.NotFound("Image not found in the trash"))
+		return
+	}
+
+	image, err := h.serv.Get(req.Context(), id)
+	if err != nil {
+		writeError(resp, req, err, "Error getting the image from db")
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(resp).Encode(image)
 }
 
 func (h *ImageHandler) show(resp http.ResponseWriter, req *http.Request) {
 	id, err := uuid.Parse(req.PathValue("id"))
 	if err != nil {
-		pkgHTTP.WriteResponse(resp, http.StatusBadRequest, "Error parsing the id")
+		pkgHTTP.WriteError(resp, req, errInvalidID)
 		return
 	}
 
 	image, err := h.serv.Get(req.Context(), id)
 	if err != nil {
-		pkgHTTP.WriteResponse(resp, http.StatusInternalServerError, "Error getting the image from db")
+		writeError(resp, req, err, "Error getting the image from db")
 		return
 	}
-	resp.Header().Set("Content-Type", image.ContentType)
-	resp.Write(image.Content)
+
+	h.showImage(resp, req, image)
 }
 
-func (h *ImageHandler) saveFilesToDB(resp http.ResponseWriter, req *http.Request) {
-	for _, header := range req.MultipartForm.File["myfiles"] {
-		file, err := header.Open()
-		if err != nil {
-			pkgHTTP.WriteResponse(resp, http.StatusBadRequest, "Error retrieving a file")
-			return
+// showImage serves the image, transformed when the query asks for it
+func (h *ImageHandler) showImage(resp http.ResponseWriter, req *http.Request, image models.Image) {
+	options, err := imaging.ParseOptions(req.URL.Query())
+	if err != nil {
+		writeError(resp, req, err, "Error parsing the options")
+		return
+	}
+
+	if !options.IsZero() {
+		h.showTransformed(resp, req, image, options)
+		return
+	}
+
+	content, err := h.serv.Open(req.Context(), image)
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, pkgHTTP.Internal("Error reading the image from storage", err))
+		return
+	}
+	defer content.Close()
+
+	h.serveContent(resp, req, image.ContentType, image.Digest, image.CreatedAt, public(image), content)
+}
+
+func (h *ImageHandler) meta(resp http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, errInvalidID)
+		return
+	}
+
+	image, err := h.serv.Get(req.Context(), id)
+	if err != nil {
+		writeError(resp, req, err, "Error getting the image from db")
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(resp).Encode(image)
+}
+
+func (h *ImageHandler) showRendition(resp http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, errInvalidID)
+		return
+	}
+
+	rendition, err := h.renditions.Get(req.Context(), id, req.PathValue("rendition"))
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			pkgHTTP.WriteError(resp, req, pkgHTTP.NotFound("Rendition not found"))
+		} else {
+			writeError(resp, req, err, "Error getting the rendition from db")
 		}
-		defer file.Close()
+		return
+	}
+
+	h.serveRendition(resp, req, rendition)
+}
+
+func (h *ImageHandler) serveRendition(resp http.ResponseWriter, req *http.Request, rendition models.Image) {
+	// clients fall back to the original image until the rendition is ready
+	resp.Header().Set("Rendition-Status", rendition.Status)
+
+	switch rendition.Status {
+	case models.STATUS_PENDING:
+		resp.Header().Set("Retry-After", "1")
+		pkgHTTP.WriteResponse(resp, http.StatusAccepted, "Rendition is being generated")
+		return
+	case models.STATUS_FAILED:
+		pkgHTTP.WriteError(resp, req, pkgHTTP.NotFound("Rendition failed"))
+		return
+	}
+
+	content, err := h.serv.Open(req.Context(), rendition)
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, pkgHTTP.Internal("Error reading the rendition from storage", err))
+		return
+	}
+	defer content.Close()
 
-		err = h.serv.SaveFileToDB(req.Context(), header.Header.Get("Content-Type"), file)
+	h.serveContent(resp, req, rendition.ContentType, rendition.Digest, rendition.CreatedAt, public(rendition), content)
+}
+
+func (h *ImageHandler) listRenditions(resp http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, errInvalidID)
+		return
+	}
+
+	renditions, err := h.renditions.List(req.Context(), id)
+	if err != nil {
+		writeError(resp, req, err, "Error getting renditions from db")
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(resp).Encode(renditions)
+}
+
+func (h *ImageHandler) showTransformed(resp http.ResponseWriter, req *http.Request, image models.Image, options imaging.Options) {
+	variant, err := h.transforms.Open(req.Context(), image, options)
+	if err != nil {
+		writeError(resp, req, err, "Error transforming the image")
+		return
+	}
+	defer variant.Close()
+
+	h.serveContent(resp, req, variant.ContentType, variant.ETag, image.CreatedAt, public(image), variant)
+}
+
+// serveContent answers conditional and range requests, so clients holding
+// a fresh copy or a part of it don't download the whole content again
+// Only public content can be kept by shared caches, the rest depends on who asks for it.
+func (h *ImageHandler) serveContent(resp http.ResponseWriter, req *http.Request, contentType, etag string, modtime time.Time, public bool, content io.ReadSeeker) {
+	header := resp.Header()
+	header.Set("Content-Type", contentType)
+	// handlers serving content that mustn't be cached set their own policy
+	switch {
+	case header.Get("Cache-Control") != "":
+	case public:
+		header.Set("Cache-Control", h.cacheControl)
+	default:
+		header.Set("Cache-Control", pkgHTTP.PRIVATE_CACHE_CONTROL)
+		header.Add("Vary", "Authorization, "+pkgHTTP.API_KEY_HEADER)
+	}
+	if etag != "" {
+		header.Set("ETag", `"`+etag+`"`)
+	}
+
+	http.ServeContent(resp, req, "", modtime, content)
+}
+
+// public tells whether everyone sees the image, renditions are seen as their image is
+func public(image models.Image) bool {
+	return image.Visibility == models.VISIBILITY_PUBLIC
+}
+
+// batchReport lists every file of a batch upload with its id or error
+type batchReport struct {
+	Mode  string                  `json:"mode"`
+	Saved int                     `json:"saved"`
+	Files []services.UploadResult `json:"files"`
+	// Error is why the request couldn't be read to its end
+	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
+}
+
+// saveFilesToDB saves every file sent as myfiles, ?mode=atomic saves all of them or none.
+// The response is 200 when all files are saved, 207 when only some of them are.
+func (h *ImageHandler) saveFilesToDB(resp http.ResponseWriter, req *http.Request, reader *multipart.Reader) {
+	mode := req.URL.Query().Get("mode")
+	batch, err := h.serv.NewBatch(mode)
+	if err != nil {
+		writeError(resp, req, err, "Error starting the batch")
+		return
+	}
+
+	report := batchReport{Mode: mode}
+	if report.Mode == "" {
+		report.Mode = services.DEFAULT_BATCH_MODE
+	}
+
+	var readErr error
+	for {
+		part, err := nextFilePart(req.Context(), reader)
 		if err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				pkgHTTP.WriteResponse(resp, http.StatusNotFound, "Image not found")
-			} else {
-				pkgHTTP.WriteResponse(resp, http.StatusInternalServerError, "Database error while retrieving image")
+			if err != io.EOF {
+				readErr = err
+				batch.Abort(req.Context(), err)
 			}
+			break
+		}
+
+		err = batch.Add(req.Context(), part.FileName(), part.Header.Get("Content-Type"), part)
+		part.Close()
+		// the rest of an atomic batch would be dropped anyway
+		if err != nil && batch.Atomic() {
+			break
 		}
+	}
+
+	err = batch.Commit(req.Context())
+	var problem *pkgHTTP.Error
+	if readErr != nil {
+		err = readErr
+		problem = problemOf(readErr, pkgHTTP.InvalidInput("Error retrieving a file"))
+		report.Error, report.Code = problem.Detail, problem.Code
+	}
+
+	report.Files = batch.Results()
+	if report.Files == nil {
+		report.Files = []services.UploadResult{}
+	}
+	for i, result := range report.Files {
+		if result.Err != nil {
+			fileProblem := problemOf(result.Err, pkgHTTP.Internal("Error saving the image", nil))
+			report.Files[i].Error, report.Files[i].Code = fileProblem.Detail, fileProblem.Code
+		}
+	}
+	report.Saved = batch.Saved()
 
-		fmt.Fprintf(resp, "File uploaded successfully: %s\n", header.Filename)
+	statusCode := http.StatusOK
+	switch {
+	case err == nil:
+	case report.Saved > 0:
+		statusCode = http.StatusMultiStatus
+	case problem != nil:
+		statusCode = problem.Status
+	default:
+		statusCode = problemOf(err, pkgHTTP.Internal("Error saving the images", nil)).Status
 	}
+
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	resp.WriteHeader(statusCode)
+	json.NewEncoder(resp).Encode(report)
 }
 
-func (h *ImageHandler) saveFiles(resp http.ResponseWriter, req *http.Request) {
+func (h *ImageHandler) saveFiles(resp http.ResponseWriter, req *http.Request, reader *multipart.Reader) {
 	// for every file
-	for _, header := range req.MultipartForm.File["myfiles"] {
-		// get file
-		file, err := header.Open()
+	for {
+		part, err := nextFilePart(req.Context(), reader)
+		if err == io.EOF {
+			return
+		}
 		if err != nil {
-			pkgHTTP.WriteResponse(resp, http.StatusBadRequest, "Error retrieving file")
+			pkgHTTP.WriteError(resp, req, problemOf(err, pkgHTTP.InvalidInput("Error retrieving file")))
 			return
 		}
-		defer file.Close()
 
-		err = h.serv.SaveFile(req.Context(), header.Filename, file)
+		file, err := h.serv.SaveFile(req.Context(), part.FileName(), part.Header.Get("Content-Type"), part)
+		part.Close()
 		if err != nil {
-			pkgHTTP.WriteResponse(resp, http.StatusInternalServerError, err.Error())
+			writeError(resp, req, err, "Error saving the file")
 			return
 		}
 
-		fmt.Fprintf(resp, "File uploaded successfully: %s\n", header.Filename)
+		if file.Name != file.OriginalName {
+			fmt.Fprintf(resp, "File uploaded successfully: %s, saved as %s\n", file.OriginalName, file.Name)
+		} else {
+			fmt.Fprintf(resp, "File uploaded successfully: %s\n", file.Name)
+		}
+	}
+}
+
+// multipartReader returns a reader streaming parts of the request body,
+// so uploads are piped into storage without buffering whole files
+func multipartReader(resp http.ResponseWriter, req *http.Request) (*multipart.Reader, bool) {
+	reader, err := req.MultipartReader()
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, pkgHTTP.InvalidInput("Expected a multipart/form-data body"))
+		return nil, false
+	}
+
+	return reader, true
+}
+
+// nextFilePart skips form values and returns the next file sent as "myfiles"
+func nextFilePart(ctx context.Context, reader *multipart.Reader) (_ *multipart.Part, err error) {
+	// parts are read up to the next file's headers, its content is streamed by whoever reads the part
+	_, span := tracer.Start(ctx, "multipart.NextPart")
+	defer func() {
+		if err != nil && err != io.EOF {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() == "myfiles" && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"testapp/internal/models"
+	"testapp/internal/services"
+	pkgHTTP "testapp/pkg/http"
+)
+
+const (
+	LINKS_PATH            = "/images/{id}/links"
+	LINK_PATH             = "/images/{id}/links/{link}"
+	SHARED_PATH           = "/s/{token}"
+	SHARED_RENDITION_PATH = "/s/{token}/{rendition}"
+)
+
+// errDownloadRefused ends serving content the link was used up for
+var errDownloadRefused = errors.New("the link is used up")
+
+// LinkHandler issues share links and serves images to anyone holding one,
+// the same way ImageHandler shows them
+type LinkHandler struct {
+	links  *services.LinkService
+	images *ImageHandler
+}
+
+func NewLinkHandler(links *services.LinkService, images *ImageHandler) *LinkHandler {
+	return &LinkHandler{links: links, images: images}
+}
+
+func (h *LinkHandler) Register(router *pkgHTTP.Router) {
+	router.With(pkgHTTP.BodyLimit(MaxJSONSize)).HandleFunc(pkgHTTP.PostPath(LINKS_PATH), h.create)
+	router.HandleFunc(pkgHTTP.GetPath(LINKS_PATH), h.list)
+	router.HandleFunc(pkgHTTP.DeletePath(LINK_PATH), h.revoke)
+	router.HandleFunc(pkgHTTP.GetPath(SHARED_PATH), h.show, pkgHTTP.Public)
+	router.HandleFunc(pkgHTTP.GetPath(SHARED_RENDITION_PATH), h.showRendition, pkgHTTP.Public)
+}
+
+// linkRequest is the body of a new link, {"ttl": "24h", "max_downloads": 3, "renditions": ["thumb"]}
+type linkRequest struct {
+	TTL          string   `json:"ttl"`
+	MaxDownloads int      `json:"max_downloads"`
+	Renditions   []string `json:"renditions"`
+}
+
+type linkResponse struct {
+	models.ShareLink
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+func (h *LinkHandler) create(resp http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, errInvalidID)
+		return
+	}
+
+	var body linkRequest
+	if req.ContentLength != 0 {
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			pkgHTTP.WriteError(resp, req, problemOf(err, pkgHTTP.InvalidInput("Error parsing the link")))
+			return
+		}
+	}
+
+	options := services.LinkOptions{MaxDownloads: body.MaxDownloads, Renditions: body.Renditions}
+	if body.TTL != "" {
+		options.TTL, err = time.ParseDuration(body.TTL)
+		if err != nil {
+			writeError(resp, req, services.ErrInvalidTTL, "Error handling the link")
+			return
+		}
+	}
+
+	link, err := h.links.Create(req.Context(), id, options)
+	if err != nil {
+		writeError(resp, req, err, "Error handling the link")
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	resp.Header().Set("Location", h.url(link))
+	resp.WriteHeader(http.StatusCreated)
+	json.NewEncoder(resp).Encode(h.response(link))
+}
+
+func (h *LinkHandler) list(resp http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, errInvalidID)
+		return
+	}
+
+	links, err := h.links.List(req.Context(), id)
+	if err != nil {
+		writeError(resp, req, err, "Error handling the link")
+		return
+	}
+
+	result := make([]linkResponse, 0, len(links))
+	for _, link := range links {
+		result = append(result, h.response(link))
+	}
+
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(resp).Encode(result)
+}
+
+func (h *LinkHandler) revoke(resp http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, errInvalidID)
+		return
+	}
+
+	linkID, err := uuid.Parse(req.PathValue("link"))
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, errInvalidLinkID)
+		return
+	}
+
+	if err := h.links.Revoke(req.Context(), id, linkID); err != nil {
+		writeError(resp, req, err, "Error handling the link")
+		return
+	}
+
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// show serves the original image of the link, transformations included
+func (h *LinkHandler) show(resp http.ResponseWriter, req *http.Request) {
+	resp, image, ok := h.resolve(resp, req, models.RENDITION_ORIGINAL)
+	if !ok {
+		return
+	}
+
+	h.images.showImage(resp, req, image)
+}
+
+func (h *LinkHandler) showRendition(resp http.ResponseWriter, req *http.Request) {
+	resp, rendition, ok := h.resolve(resp, req, req.PathValue("rendition"))
+	if !ok {
+		return
+	}
+
+	h.images.serveRendition(resp, req, rendition)
+}
+
+// resolve returns the image of the link and the writer to serve it with, which counts the download
+func (h *LinkHandler) resolve(resp http.ResponseWriter, req *http.Request, rendition string) (http.ResponseWriter, models.Image, bool) {
+	// links can be revoked or used up, so copies mustn't outlive them
+	resp.Header().Set("Cache-Control", "private, no-store")
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+
+	image, link, err := h.links.Resolve(req.Context(), req.PathValue("token"), rendition)
+	if err != nil {
+		writeError(resp, req, err, "Error handling the link")
+		return resp, models.Image{}, false
+	}
+
+	return &downloadCounter{ResponseWriter: resp, req: req, links: h.links, link: link}, image, true
+}
+
+func (h *LinkHandler) response(link models.ShareLink) linkResponse {
+	return linkResponse{ShareLink: link, Token: h.links.Token(link), URL: h.url(link)}
+}
+
+func (h *LinkHandler) url(link models.ShareLink) string {
+	return "/s/" + h.links.Token(link)
+}
+
+// downloadCounter counts a download of the link when the image is sent in full. Ranges,
+// unchanged images and pending renditions aren't downloads, a browser asks for them often.
+// The content isn't sent when the link got used up since it was resolved.
+type downloadCounter struct {
+	http.ResponseWriter
+	req         *http.Request
+	links       *services.LinkService
+	link        models.ShareLink
+	wroteHeader bool
+	refused     bool
+}
+
+func (c *downloadCounter) WriteHeader(status int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+
+	if status == http.StatusOK && c.req.Method != http.MethodHead {
+		if err := c.links.Count(c.req.Context(), c.link); err != nil {
+			c.refused = true
+			// the headers describe the content, not the error
+			for _, name := range []string{"Content-Length", "Content-Range", "Content-Encoding", "Accept-Ranges", "ETag", "Last-Modified"} {
+				c.Header().Del(name)
+			}
+			writeError(c.ResponseWriter, c.req, err, "Error handling the link")
+			return
+		}
+	}
+
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *downloadCounter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.refused {
+		return 0, errDownloadRefused
+	}
+
+	return c.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the connection's writer for flushing and deadlines
+func (c *downloadCounter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	pkgHTTP "testapp/pkg/http"
+	"testapp/pkg/metrics"
+)
+
+type MetricsHandler struct {
+	conf metrics.Config
+}
+
+func NewMetricsHandler(conf metrics.Config) *MetricsHandler {
+	return &MetricsHandler{conf: conf}
+}
+
+// Register serves the metrics to admins, or to anyone when they are public
+// so scrapers don't need credentials
+func (h *MetricsHandler) Register(router *pkgHTTP.Router) {
+	path := h.conf.Path
+	if path == "" {
+		path = metrics.DEFAULT_PATH
+	}
+
+	requirement := pkgHTTP.RequireRole(pkgHTTP.ROLE_ADMIN)
+	if h.conf.Public {
+		requirement = pkgHTTP.Public
+	}
+
+	router.Handle(pkgHTTP.GetPath(path), metrics.Handler(), requirement)
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"testapp/internal/models"
+	pkgHTTP "testapp/pkg/http"
+)
+
+type updateRequest struct {
+	Visibility string `json:"visibility"`
+}
+
+type grantRequest struct {
+	Permission string `json:"permission"`
+}
+
+// update changes the visibility of an image, only its owner can
+func (h *ImageHandler) update(resp http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, errInvalidID)
+		return
+	}
+
+	var update updateRequest
+	if err := json.NewDecoder(req.Body).Decode(&update); err != nil {
+		pkgHTTP.WriteError(resp, req, problemOf(err, pkgHTTP.InvalidInput("Error parsing the update")))
+		return
+	}
+
+	image, err := h.serv.SetVisibility(req.Context(), id, update.Visibility)
+	if err != nil {
+		writeError(resp, req, err, "Error sharing the image")
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(resp).Encode(image)
+}
+
+func (h *ImageHandler) listGrants(resp http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, errInvalidID)
+		return
+	}
+
+	grants, err := h.serv.Grants(req.Context(), id)
+	if err != nil {
+		writeError(resp, req, err, "Error sharing the image")
+		return
+	}
+
+	if grants == nil {
+		grants = []models.ImageGrant{}
+	}
+
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(resp).Encode(grants)
+}
+
+// grant shares an image with the user in the path, {"permission": "read"} or "write"
+func (h *ImageHandler) grant(resp http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, errInvalidID)
+		return
+	}
+
+	var grant grantRequest
+	if err := json.NewDecoder(req.Body).Decode(&grant); err != nil {
+		pkgHTTP.WriteError(resp, req, problemOf(err, pkgHTTP.InvalidInput("Error parsing the grant")))
+		return
+	}
+
+	granted, err := h.serv.Grant(req.Context(), id, req.PathValue("subject"), grant.Permission)
+	if err != nil {
+		writeError(resp, req, err, "Error sharing the image")
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(resp).Encode(granted)
+}
+
+func (h *ImageHandler) revoke(resp http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, errInvalidID)
+		return
+	}
+
+	if err := h.serv.Revoke(req.Context(), id, req.PathValue("subject")); err != nil {
+		writeError(resp, req, err, "Error sharing the image")
+		return
+	}
+
+	resp.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"testapp/internal/models"
+	"testapp/internal/repositories"
+	"testapp/internal/services"
+	pkgHTTP "testapp/pkg/http"
+)
+
+const (
+	TUS_PATH        = "/files"
+	TUS_UPLOAD_PATH = "/files/{id}"
+
+	TUS_VERSION    = "1.0.0"
+	TUS_EXTENSIONS = "creation,termination,expiration"
+
+	TUS_CONTENT_TYPE = "application/offset+octet-stream"
+)
+
+type TusHandler struct {
+	serv *services.UploadService
+
+	maxUploadSize int64
+}
+
+func NewTusHandler(serv *services.UploadService) *TusHandler {
+	return &TusHandler{serv: serv, maxUploadSize: maxUploadSize(serv.MaxSize())}
+}
+
+func (h *TusHandler) Register(router *pkgHTTP.Router) {
+	// clients discover the server's capabilities before authenticating
+	router.HandleFunc(pkgHTTP.OptionsPath(TUS_PATH), h.options, pkgHTTP.Public)
+
+	tus := router.Group(resumable)
+	tus.HandleFunc(pkgHTTP.PostPath(TUS_PATH), h.create)
+	tus.HandleFunc(pkgHTTP.HeadPath(TUS_UPLOAD_PATH), h.head)
+	tus.With(pkgHTTP.BodyLimit(h.maxUploadSize)).HandleFunc(pkgHTTP.PatchPath(TUS_UPLOAD_PATH), h.patch)
+	tus.HandleFunc(pkgHTTP.DeletePath(TUS_UPLOAD_PATH), h.terminate)
+}
+
+func (h *TusHandler) options(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Tus-Resumable", TUS_VERSION)
+	resp.Header().Set("Tus-Version", TUS_VERSION)
+	resp.Header().Set("Tus-Extension", TUS_EXTENSIONS)
+	resp.Header().Set("Tus-Max-Size", strconv.FormatInt(h.maxUploadSize, 10))
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TusHandler) create(resp http.ResponseWriter, req *http.Request) {
+	length, err := strconv.ParseInt(req.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		pkgHTTP.WriteError(resp, req, pkgHTTP.InvalidInput("Invalid Upload-Length", pkgHTTP.FieldError{Field: "Upload-Length", Detail: "must be a non-negative integer"}))
+		return
+	}
+
+	if length > h.maxUploadSize {
+		pkgHTTP.WriteError(resp, req, pkgHTTP.NewError(http.StatusRequestEntityTooLarge, pkgHTTP.CODE_TOO_LARGE, "File is too large"))
+		return
+	}
+
+	rawMetadata := req.Header.Get("Upload-Metadata")
+	metadata, err := parseUploadMetadata(rawMetadata)
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, pkgHTTP.InvalidInput("Invalid Upload-Metadata", pkgHTTP.FieldError{Field: "Upload-Metadata", Detail: "must be comma separated keys with base64 values"}))
+		return
+	}
+
+	upload, err := h.serv.Create(req.Context(), length, metadata["filetype"], metadata["filename"], rawMetadata)
+	if err != nil {
+		writeError(resp, req, err, "Error creating the upload")
+		return
+	}
+
+	resp.Header().Set("Location", TUS_PATH+"/"+upload.ID.String())
+	setUploadHeaders(resp, upload)
+	resp.WriteHeader(http.StatusCreated)
+}
+
+func (h *TusHandler) head(resp http.ResponseWriter, req *http.Request) {
+	upload, ok := h.getUpload(resp, req)
+	if !ok {
+		return
+	}
+
+	resp.Header().Set("Cache-Control", "no-store")
+	resp.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		resp.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+	setUploadHeaders(resp, upload)
+	resp.WriteHeader(http.StatusOK)
+}
+
+func (h *TusHandler) patch(resp http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Content-Type") != TUS_CONTENT_TYPE {
+		pkgHTTP.WriteError(resp, req, pkgHTTP.NewError(http.StatusUnsupportedMediaType, pkgHTTP.CODE_UNSUPPORTED_TYPE, "Content-Type must be "+TUS_CONTENT_TYPE))
+		return
+	}
+
+	offset, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		pkgHTTP.WriteError(resp, req, pkgHTTP.InvalidInput("Invalid Upload-Offset", pkgHTTP.FieldError{Field: "Upload-Offset", Detail: "must be a non-negative integer"}))
+		return
+	}
+
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, errUploadNotFound)
+		return
+	}
+
+	upload, err := h.serv.Append(req.Context(), id, offset, req.Body)
+	if err != nil {
+		writeTusError(resp, req, err, "Error saving the upload")
+		return
+	}
+
+	setUploadHeaders(resp, upload)
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TusHandler) terminate(resp http.ResponseWriter, req *http.Request) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		pkgHTTP.WriteError(resp, req, errUploadNotFound)
+		return
+	}
+
+	if err := h.serv.Terminate(req.Context(), id); err != nil {
+		writeTusError(resp, req, err, "Error terminating the upload")
+		return
+	}
+
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TusHandler) getUpload(resp http.ResponseWriter, req *http.Request) (models.Upload, bool) {
+	id, err := uuid.Parse(req.PathValue("id"))
+	if err != nil {
+		resp.WriteHeader(http.StatusNotFound)
+		return models.Upload{}, false
+	}
+
+	upload, err := h.serv.Get(req.Context(), id)
+	if err != nil {
+		writeTusError(resp, req, err, "Error getting the upload")
+		return models.Upload{}, false
+	}
+
+	return upload, true
+}
+
+// resumable checks the protocol version every tus request except OPTIONS must carry
+func resumable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Tus-Resumable", TUS_VERSION)
+
+		if req.Header.Get("Tus-Resumable") != TUS_VERSION {
+			resp.Header().Set("Tus-Version", TUS_VERSION)
+			pkgHTTP.WriteError(resp, req, pkgHTTP.NewError(http.StatusPreconditionFailed, pkgHTTP.CODE_PRECONDITION_FAILED, "Unsupported Tus-Resumable version"))
+			return
+		}
+
+		next.ServeHTTP(resp, req)
+	})
+}
+
+func setUploadHeaders(resp http.ResponseWriter, upload models.Upload) {
+	resp.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	resp.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if upload.ImageID != nil {
+		resp.Header().Set("Image-Id", upload.ImageID.String())
+	}
+}
+
+// writeTusError tells uploads apart from images, which are what's usually not found
+func writeTusError(resp http.ResponseWriter, req *http.Request, err error, explanation string) {
+	if errors.Is(err, repositories.ErrNotFound) {
+		pkgHTTP.WriteError(resp, req, errUploadNotFound)
+		return
+	}
+
+	writeError(resp, req, err, explanation)
+}
+
+// parseUploadMetadata decodes "key base64value,key base64value" pairs
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if header == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(decoded)
+	}
+
+	return metadata, nil
+}
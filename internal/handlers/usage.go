@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"testapp/internal/services"
+	pkgHTTP "testapp/pkg/http"
+)
+
+const (
+	USAGE_PATH        = "/me/usage"
+	USAGE_REPORT_PATH = "/usage"
+)
+
+type UsageHandler struct {
+	serv *services.ImageService
+}
+
+func NewUsageHandler(serv *services.ImageService) *UsageHandler {
+	return &UsageHandler{serv: serv}
+}
+
+func (h *UsageHandler) Register(router *pkgHTTP.Router) {
+	router.HandleFunc(pkgHTTP.GetPath(USAGE_PATH), h.usage)
+	router.HandleFunc(pkgHTTP.GetPath(USAGE_REPORT_PATH), h.report, pkgHTTP.RequireRole(pkgHTTP.ROLE_ADMIN))
+}
+
+// usage returns what the principal stores with its quota
+func (h *UsageHandler) usage(resp http.ResponseWriter, req *http.Request) {
+	usage, err := h.serv.Usage(req.Context())
+	if err != nil {
+		writeError(resp, req, err, "Error getting the usage from db")
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(resp).Encode(usage)
+}
+
+// report lists usage of every user, largest first
+func (h *UsageHandler) report(resp http.ResponseWriter, req *http.Request) {
+	report, err := h.serv.UsageReport(req.Context())
+	if err != nil {
+		writeError(resp, req, err, "Error getting the usage from db")
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(resp).Encode(report)
+}
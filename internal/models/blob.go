@@ -0,0 +1,17 @@
+package models
+
+func NewBlob(digest, storageKey string, size int64) Blob {
+	return Blob{
+		Digest:     digest,
+		StorageKey: storageKey,
+		Size:       size,
+		RefCount:   1,
+	}
+}
+
+type Blob struct {
+	Digest     string `json:"digest" gorm:"type:char(64);primaryKey"`
+	StorageKey string `json:"-" gorm:"type:varchar(255);not null"`
+	Size       int64  `json:"size" gorm:"not null"`
+	RefCount   int64  `json:"ref_count" gorm:"not null;default:0"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+func NewFile(name, originalName, contentType string) File {
+	return File{
+		Name:         name,
+		OriginalName: originalName,
+		ContentType:  contentType,
+	}
+}
+
+// File is a file uploaded to the filesystem, stored under a sanitized name
+type File struct {
+	Name         string    `json:"name" gorm:"type:varchar(255);primaryKey"`
+	OriginalName string    `json:"original_name" gorm:"type:text"` // as sent by the client
+	OwnerID      string    `json:"owner_id,omitempty" gorm:"type:varchar(255);index"`
+	ContentType  string    `json:"content_type" gorm:"type:varchar(255);not null"`
+	Digest       string    `json:"digest" gorm:"type:char(64)"`
+	Size         int64     `json:"size" gorm:"not null;default:0"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	PERMISSION_READ  = "read"
+	PERMISSION_WRITE = "write" // deleting and restoring, reading included
+)
+
+func ValidPermission(permission string) bool {
+	return permission == PERMISSION_READ || permission == PERMISSION_WRITE
+}
+
+func NewImageGrant(imageID uuid.UUID, subject, permission string) ImageGrant {
+	return ImageGrant{ImageID: imageID, Subject: subject, Permission: permission}
+}
+
+// ImageGrant shares an image with another user
+type ImageGrant struct {
+	ImageID    uuid.UUID `json:"image_id" gorm:"type:uuid;primaryKey"`
+	Subject    string    `json:"subject" gorm:"type:varchar(255);primaryKey;index"`
+	Permission string    `json:"permission" gorm:"type:varchar(8);not null"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -1,19 +1,72 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"testapp/pkg/exif"
+)
+
+const (
+	STATUS_PENDING = "pending"
+	STATUS_READY   = "ready"
+	STATUS_FAILED  = "failed"
 )
 
-func NewImage(id uuid.UUID, contentType string, content []byte) Image {
+// who can see an image besides its owner and users it's shared with
+const (
+	VISIBILITY_PRIVATE  = "private"
+	VISIBILITY_UNLISTED = "unlisted" // anyone knowing its id, it isn't listed
+	VISIBILITY_PUBLIC   = "public"
+)
+
+func ValidVisibility(visibility string) bool {
+	return visibility == VISIBILITY_PRIVATE || visibility == VISIBILITY_UNLISTED || visibility == VISIBILITY_PUBLIC
+}
+
+func NewImage(id uuid.UUID, filename, contentType, digest, storageKey string, size int64) Image {
 	return Image{
 		ID:          id,
+		Filename:    filename,
 		ContentType: contentType,
-		Content:     content,
+		Digest:      digest,
+		StorageKey:  storageKey,
+		Size:        size,
+		Status:      STATUS_READY,
+		Visibility:  VISIBILITY_PRIVATE,
+	}
+}
+
+// NewRendition creates a pending child record, its content is stored once it's generated
+func NewRendition(id, parentID uuid.UUID, name, contentType string) Image {
+	return Image{
+		ID:          id,
+		ContentType: contentType,
+		ParentID:    &parentID,
+		Rendition:   name,
+		Status:      STATUS_PENDING,
 	}
 }
 
 type Image struct {
-	ID          uuid.UUID `json:"id" gorm:"type:uuid;primaryKey"`
-	ContentType string    `json:"content_type" gorm:"type:varchar(255);not null"`
-	Content     []byte    `json:"content" gorm:"type:bytea;not null"`
-}
\ No newline at end of file
+	ID          uuid.UUID      `json:"id" gorm:"type:uuid;primaryKey;index:idx_images_list_created,priority:2;index:idx_images_list_size,priority:2"`
+	ContentType string         `json:"content_type" gorm:"type:varchar(255);not null"`
+	Digest      string         `json:"digest" gorm:"type:char(64);index"`
+	StorageKey  string         `json:"-" gorm:"type:varchar(255);not null"`
+	Size        int64          `json:"size" gorm:"not null;index:idx_images_list_size,priority:1,where:parent_id IS NULL AND deleted_at IS NULL"`
+	ParentID    *uuid.UUID     `json:"parent_id,omitempty" gorm:"type:uuid;uniqueIndex:idx_images_rendition"`
+	Rendition   string         `json:"rendition,omitempty" gorm:"type:varchar(64);uniqueIndex:idx_images_rendition"`
+	Status      string         `json:"status" gorm:"type:varchar(16);not null;default:ready"`
+	Filename    string         `json:"filename,omitempty" gorm:"type:varchar(255)"`
+	OwnerID     string         `json:"owner_id,omitempty" gorm:"type:varchar(255);index"`
+	Visibility  string         `json:"visibility,omitempty" gorm:"type:varchar(16);not null;default:private"`
+	Width       int            `json:"width" gorm:"index"`
+	Height      int            `json:"height" gorm:"index"`
+	ColorModel  string         `json:"color_model,omitempty" gorm:"type:varchar(16)"`
+	Frames      int            `json:"frames"`
+	Exif        *exif.Data     `json:"exif,omitempty" gorm:"type:jsonb;serializer:json;index:,type:gin"`
+	CreatedAt   time.Time      `json:"created_at" gorm:"index:idx_images_list_created,priority:1,where:parent_id IS NULL AND deleted_at IS NULL"`
+	DeletedAt   gorm.DeletedAt `json:"deleted_at" gorm:"index"` // trashed until purged
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RENDITION_ORIGINAL names the uploaded image among renditions a link allows
+const RENDITION_ORIGINAL = "original"
+
+func NewShareLink(id, imageID uuid.UUID, createdBy string, expiresAt time.Time, maxDownloads int, renditions []string) ShareLink {
+	return ShareLink{
+		ID:           id,
+		ImageID:      imageID,
+		CreatedBy:    createdBy,
+		ExpiresAt:    expiresAt,
+		MaxDownloads: maxDownloads,
+		Renditions:   renditions,
+	}
+}
+
+// ShareLink lets anyone holding its signed token download an image until it expires
+type ShareLink struct {
+	ID           uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey"`
+	ImageID      uuid.UUID  `json:"image_id" gorm:"type:uuid;not null;index"`
+	CreatedBy    string     `json:"created_by" gorm:"type:varchar(255)"`
+	ExpiresAt    time.Time  `json:"expires_at" gorm:"not null"`
+	MaxDownloads int        `json:"max_downloads,omitempty" gorm:"not null;default:0"` // unlimited when 0
+	Downloads    int        `json:"downloads" gorm:"not null;default:0"`
+	Renditions   []string   `json:"renditions,omitempty" gorm:"type:jsonb;serializer:json"` // all of them when empty
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// Allows tells whether the rendition, or the original, can be downloaded with the link
+func (l ShareLink) Allows(rendition string) bool {
+	return len(l.Renditions) == 0 || slices.Contains(l.Renditions, rendition)
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func NewUpload(id uuid.UUID, length int64, contentType, filename, metadata string, expiresAt time.Time) Upload {
+	return Upload{
+		ID:          id,
+		Length:      length,
+		ContentType: contentType,
+		Filename:    filename,
+		Metadata:    metadata,
+		ExpiresAt:   expiresAt,
+	}
+}
+
+type Upload struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey"`
+	Length      int64      `json:"length" gorm:"column:upload_length;not null"`
+	Offset      int64      `json:"offset" gorm:"column:upload_offset;not null;default:0"`
+	ContentType string     `json:"content_type" gorm:"type:varchar(255)"`
+	Filename    string     `json:"filename" gorm:"type:varchar(255)"`
+	Metadata    string     `json:"metadata" gorm:"type:text"`
+	OwnerID     string     `json:"owner_id" gorm:"type:varchar(255);index"` // only its creator can resume the upload
+	ImageID     *uuid.UUID `json:"image_id" gorm:"type:uuid"`
+	ExpiresAt   time.Time  `json:"expires_at" gorm:"not null;index"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (u Upload) Completed() bool {
+	return u.Offset == u.Length
+}
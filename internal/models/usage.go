@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Usage is what a user stores. Original images and files are counted,
+// renditions are generated by the service and aren't.
+type Usage struct {
+	Subject   string    `json:"subject,omitempty" gorm:"type:varchar(255);primaryKey"`
+	Bytes     int64     `json:"bytes" gorm:"not null;default:0"`
+	Objects   int64     `json:"objects" gorm:"not null;default:0"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
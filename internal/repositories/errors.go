@@ -0,0 +1,14 @@
+package repositories
+
+import "errors"
+
+// Repositories return these instead of their database's errors,
+// so callers don't depend on how records are stored
+var (
+	// ErrNotFound is returned when the record doesn't exist
+	ErrNotFound = errors.New("record not found")
+	// ErrConflict is returned when a change clashes with the stored records or with a concurrent change
+	ErrConflict = errors.New("conflicting change")
+	// ErrUnavailable is returned when the database can't be reached, trying again later may succeed
+	ErrUnavailable = errors.New("database unavailable")
+)
@@ -0,0 +1,16 @@
+package repositories
+
+import (
+	"context"
+
+	"testapp/internal/models"
+)
+
+type FileRepository interface {
+	// Reserve creates the file unless its name is taken and tells whether it did.
+	Reserve(ctx context.Context, file models.File) (bool, error)
+	// Save creates the file or replaces the one with the same name, counting it for its owner.
+	Save(ctx context.Context, file models.File) error
+	Get(ctx context.Context, name string) (models.File, error)
+	Delete(ctx context.Context, name string) error
+}
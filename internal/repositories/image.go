@@ -2,14 +2,81 @@ package repositories
 
 import (
 	"context"
+	"time"
+
 	"github.com/google/uuid"
 
 	"testapp/internal/models"
 )
 
+const (
+	SORT_CREATED_AT = "created_at"
+	SORT_SIZE       = "size"
+)
+
+// Viewer is who images are looked up for. Anonymous viewers have no subject,
+// admins see every image.
+type Viewer struct {
+	Subject string
+	Admin   bool
+}
+
+type PageQuery struct {
+	// Viewer sees own, shared and public images
+	Viewer       Viewer
+	Limit        int
+	Sort         string
+	Desc         bool
+	ContentTypes []string
+	// After is the last image of the previous page. Pages are selected by their sort key
+	// instead of an offset, so deep pages are as fast as the first one.
+	After *models.Image
+}
+
+// ImageStats counts original images, renditions aren't included
+type ImageStats struct {
+	Images       int64
+	Bytes        int64
+	Trashed      int64
+	TrashedBytes int64
+}
+
 type ImageRepository interface {
-	Paginate(ctx context.Context, limit, offset int) ([]models.Image, error)
-	Create(ctx context.Context, image models.Image) error
+	// Paginate lists original images, renditions aren't included.
+	Paginate(ctx context.Context, query PageQuery) ([]models.Image, error)
+	// Create stores the image and takes a reference on its blob. When a blob with
+	// the same digest already exists, the returned image points at its storage key.
+	Create(ctx context.Context, image models.Image) (models.Image, error)
+	// CreateAll creates the images in one transaction, none is created when one of them fails.
+	CreateAll(ctx context.Context, images []models.Image) ([]models.Image, error)
 	Get(ctx context.Context, id uuid.UUID) (models.Image, error)
-	Delete(ctx context.Context, ids []uuid.UUID) error
-}
\ No newline at end of file
+	// Update saves the image, taking a blob reference when it got content.
+	Update(ctx context.Context, image models.Image) (models.Image, error)
+	GetRendition(ctx context.Context, parentID uuid.UUID, name string) (models.Image, error)
+	Renditions(ctx context.Context, parentID uuid.UUID) ([]models.Image, error)
+	// Pending returns up to limit renditions waiting to be generated, oldest first.
+	// Renditions of trashed images aren't included.
+	Pending(ctx context.Context, limit int) ([]models.Image, error)
+	// Delete moves the images with their renditions to the trash and returns ids of the moved images.
+	Delete(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error)
+	// Restore takes the images with their renditions out of the trash and returns ids of the restored images.
+	Restore(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error)
+	// Trashed returns ids of up to limit images deleted before the given time.
+	Trashed(ctx context.Context, before time.Time, limit int) ([]uuid.UUID, error)
+	// Purge removes the images deleted before the given time with their renditions for good
+	// and returns ids of the purged images and storage keys that are no longer referenced.
+	// Images restored in the meantime are kept.
+	Purge(ctx context.Context, ids []uuid.UUID, before time.Time) ([]uuid.UUID, []string, error)
+	// Writable returns ids of the images, trashed ones included, the viewer may change.
+	Writable(ctx context.Context, ids []uuid.UUID, viewer Viewer) ([]uuid.UUID, error)
+	// Permission returns what the image was shared with the subject for, empty when it wasn't.
+	Permission(ctx context.Context, id uuid.UUID, subject string) (string, error)
+	SetVisibility(ctx context.Context, id uuid.UUID, visibility string) error
+	Grants(ctx context.Context, id uuid.UUID) ([]models.ImageGrant, error)
+	// Grant shares the image, replacing the permission the subject had.
+	Grant(ctx context.Context, grant models.ImageGrant) (models.ImageGrant, error)
+	// Revoke stops sharing the image with the subject and tells whether it was shared.
+	Revoke(ctx context.Context, id uuid.UUID, subject string) (bool, error)
+	// Stats counts the images of every user, in use and in the trash.
+	Stats(ctx context.Context) (ImageStats, error)
+}
@@ -0,0 +1,21 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"testapp/internal/models"
+)
+
+type LinkRepository interface {
+	Create(ctx context.Context, link models.ShareLink) (models.ShareLink, error)
+	Get(ctx context.Context, id uuid.UUID) (models.ShareLink, error)
+	// List returns links of the image, revoked and expired ones included.
+	List(ctx context.Context, imageID uuid.UUID) ([]models.ShareLink, error)
+	// Revoke revokes the link of the image and tells whether it was usable.
+	Revoke(ctx context.Context, imageID, id uuid.UUID) (bool, error)
+	// Use counts a download and tells whether the link was usable for it. Links are
+	// counted in one statement, so concurrent downloads can't exceed their maximum.
+	Use(ctx context.Context, id uuid.UUID) (bool, error)
+}
@@ -0,0 +1,54 @@
+package pgsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+
+	"testapp/internal/repositories"
+)
+
+// translate turns gorm and driver errors into the repositories' ones,
+// the original error stays wrapped for the logs when it tells more
+func translate(err error) error {
+	if err == nil || errors.Is(err, repositories.ErrNotFound) || errors.Is(err, repositories.ErrConflict) ||
+		errors.Is(err, repositories.ErrUnavailable) {
+		return err
+	}
+
+	var (
+		pgErr      *pgconn.PgError
+		connectErr *pgconn.ConnectError
+		netErr     net.Error
+	)
+	switch {
+	// requests that gave up or ran out of time aren't the database's fault, the driver
+	// reports them as network timeouts, so they are told apart first
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return repositories.ErrNotFound
+	case errors.Is(err, gorm.ErrDuplicatedKey), errors.Is(err, gorm.ErrForeignKeyViolated):
+		return fmt.Errorf("%w: %w", repositories.ErrConflict, err)
+	case errors.As(err, &pgErr):
+		switch {
+		// integrity constraint violations, serialization failures and deadlocks
+		case strings.HasPrefix(pgErr.Code, "23"), pgErr.Code == "40001", pgErr.Code == "40P01":
+			return fmt.Errorf("%w: %w", repositories.ErrConflict, err)
+		// connection exceptions, insufficient resources, the server shutting down or starting
+		case strings.HasPrefix(pgErr.Code, "08"), strings.HasPrefix(pgErr.Code, "53"), strings.HasPrefix(pgErr.Code, "57P"):
+			return fmt.Errorf("%w: %w", repositories.ErrUnavailable, err)
+		}
+	case errors.As(err, &connectErr), errors.As(err, &netErr), errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone):
+		return fmt.Errorf("%w: %w", repositories.ErrUnavailable, err)
+	}
+
+	return err
+}
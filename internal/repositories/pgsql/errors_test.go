@@ -0,0 +1,45 @@
+package pgsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"testapp/internal/repositories"
+)
+
+func TestTranslate(t *testing.T) {
+	other := errors.New("syntax error")
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{name: "not found", err: gorm.ErrRecordNotFound, want: repositories.ErrNotFound},
+		{name: "unique violation", err: fmt.Errorf("insert: %w", &pgconn.PgError{Code: "23505"}), want: repositories.ErrConflict},
+		{name: "deadlock", err: &pgconn.PgError{Code: "40P01"}, want: repositories.ErrConflict},
+		{name: "shutting down", err: &pgconn.PgError{Code: "57P01"}, want: repositories.ErrUnavailable},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Err: errors.New("refused")}, want: repositories.ErrUnavailable},
+		// context errors are net errors as well, they mustn't look like the database is down
+		{name: "deadline exceeded", err: fmt.Errorf("query: %w", context.DeadlineExceeded), want: context.DeadlineExceeded},
+		{name: "canceled", err: fmt.Errorf("query: %w", context.Canceled), want: context.Canceled},
+		{name: "other errors", err: other, want: other},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			translated := translate(test.err)
+			assert.ErrorIs(t, translated, test.want)
+			if !errors.Is(test.want, repositories.ErrUnavailable) {
+				assert.NotErrorIs(t, translated, repositories.ErrUnavailable)
+			}
+		})
+	}
+}
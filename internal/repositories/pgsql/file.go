@@ -0,0 +1,78 @@
+package pgsql
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"testapp/internal/models"
+)
+
+func NewFileRepository(conn *gorm.DB) *FileRepository {
+	return &FileRepository{conn: conn}
+}
+
+type FileRepository struct {
+	conn *gorm.DB
+}
+
+// Reserve relies on the primary key, so concurrent uploads can't take the same name
+func (r *FileRepository) Reserve(ctx context.Context, file models.File) (bool, error) {
+	tx := r.conn.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&file)
+	if tx.Error != nil {
+		return false, translate(tx.Error)
+	}
+
+	return tx.RowsAffected == 1, nil
+}
+
+func (r *FileRepository) Save(ctx context.Context, file models.File) error {
+	return translate(r.conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// a replaced file is no longer counted for its previous owner
+		if err := releaseFile(tx, file.Name); err != nil {
+			return err
+		}
+
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"original_name", "owner_id", "content_type", "digest", "size", "updated_at"}),
+		}).Create(&file).Error
+		if err != nil {
+			return err
+		}
+
+		return addUsage(tx, file.OwnerID, file.Size, 1)
+	}))
+}
+
+func (r *FileRepository) Get(ctx context.Context, name string) (file models.File, err error) {
+	err = r.conn.WithContext(ctx).Where("name = ?", name).First(&file).Error
+	if err != nil {
+		return models.File{}, translate(err)
+	}
+
+	return file, nil
+}
+
+func (r *FileRepository) Delete(ctx context.Context, name string) error {
+	return translate(r.conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := releaseFile(tx, name); err != nil {
+			return err
+		}
+
+		return tx.Where("name = ?", name).Delete(&models.File{}).Error
+	}))
+}
+
+// releaseFile stops counting the stored file for its owner,
+// reserved names without content aren't counted
+func releaseFile(tx *gorm.DB, name string) error {
+	var files []models.File
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("name = ?", name).Limit(1).Find(&files).Error
+	if err != nil || len(files) == 0 || files[0].Digest == "" {
+		return err
+	}
+
+	return addUsage(tx, files[0].OwnerID, -files[0].Size, -1)
+}
@@ -1,11 +1,16 @@
 package pgsql
 
 import (
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"context"
+	"time"
 
 	"testapp/internal/models"
+	"testapp/internal/repositories"
 )
 
 func NewImageRepository(conn *gorm.DB) *ImageRepository {
@@ -16,32 +21,436 @@ type ImageRepository struct {
 	conn *gorm.DB
 }
 
-func (r *ImageRepository) Paginate(ctx context.Context, limit, offset int) (images []models.Image, err error) {
-	err = r.conn.WithContext(ctx).Limit(limit).Offset(offset).Find(&images).Error
+func (r *ImageRepository) Paginate(ctx context.Context, query repositories.PageQuery) (images []models.Image, err error) {
+	column := repositories.SORT_CREATED_AT
+	if query.Sort == repositories.SORT_SIZE {
+		column = repositories.SORT_SIZE
+	}
+
+	tx := r.conn.WithContext(ctx).Where("parent_id IS NULL")
+	if !query.Viewer.Admin {
+		tx = tx.Where(visibleTo(r.conn, query.Viewer.Subject))
+	}
+	if len(query.ContentTypes) > 0 {
+		tx = tx.Where("content_type IN ?", query.ContentTypes)
+	}
+
+	// the id breaks ties, so every row has a distinct position
+	if query.After != nil {
+		var after any = query.After.CreatedAt
+		if column == repositories.SORT_SIZE {
+			after = query.After.Size
+		}
+
+		operator := ">"
+		if query.Desc {
+			operator = "<"
+		}
+		tx = tx.Where(fmt.Sprintf("(%s, id) %s (?, ?)", column, operator), after, query.After.ID)
+	}
+
+	tx = tx.Order(clause.OrderBy{Columns: []clause.OrderByColumn{
+		{Column: clause.Column{Name: column}, Desc: query.Desc},
+		{Column: clause.Column{Name: "id"}, Desc: query.Desc},
+	}})
+
+	err = tx.Limit(query.Limit).Find(&images).Error
 	if err != nil {
-		return []models.Image{}, err
+		return []models.Image{}, translate(err)
 	}
 	
 	return images, nil
 }
 
-func (r *ImageRepository) Create(ctx context.Context, image models.Image) error {
-	return r.conn.WithContext(ctx).Create(&image).Error
+func (r *ImageRepository) Create(ctx context.Context, image models.Image) (models.Image, error) {
+	err := r.conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := takeBlob(tx, &image); err != nil {
+			return err
+		}
+
+		if err := tx.Create(&image).Error; err != nil {
+			return err
+		}
+
+		return addImagesUsage(tx, []models.Image{image}, 1)
+	})
+	if err != nil {
+		return models.Image{}, translate(err)
+	}
+
+	return image, nil
+}
+
+func (r *ImageRepository) CreateAll(ctx context.Context, images []models.Image) ([]models.Image, error) {
+	created := make([]models.Image, len(images))
+	copy(created, images)
+
+	err := r.conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// one by one, so images with the same content in a batch share a blob
+		for i := range created {
+			if err := takeBlob(tx, &created[i]); err != nil {
+				return err
+			}
+
+			if err := tx.Create(&created[i]).Error; err != nil {
+				return err
+			}
+		}
+
+		return addImagesUsage(tx, created, 1)
+	})
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	return created, nil
+}
+
+func (r *ImageRepository) Update(ctx context.Context, image models.Image) (models.Image, error) {
+	err := r.conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var stored models.Image
+		if err := tx.Select("digest").Where("id = ?", image.ID).First(&stored).Error; err != nil {
+			return err
+		}
+
+		if stored.Digest != image.Digest {
+			if stored.Digest != "" {
+				return errors.New("image content can't be replaced")
+			}
+
+			if err := takeBlob(tx, &image); err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&image).Select("content_type", "digest", "storage_key", "size", "status", "width", "height", "color_model", "frames", "exif").Updates(&image).Error
+	})
+	if err != nil {
+		return models.Image{}, translate(err)
+	}
+
+	return image, nil
+}
+
+// takeBlob takes a reference on the existing blob with the image's digest or inserts a new one,
+// pointing the image at the blob's storage key
+func takeBlob(tx *gorm.DB, image *models.Image) error {
+	if image.Digest == "" {
+		return nil
+	}
+
+	blob := models.NewBlob(image.Digest, image.StorageKey, image.Size)
+
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "digest"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("blobs.ref_count + 1")}),
+	}, clause.Returning{}).Create(&blob).Error
+	if err != nil {
+		return err
+	}
+
+	image.StorageKey = blob.StorageKey
+	return nil
 }
 
 func (r *ImageRepository) Get(ctx context.Context, id uuid.UUID) (image models.Image, err error) {
 	err = r.conn.WithContext(ctx).Where("id = ?", id).First(&image).Error
 	if err != nil {
-		return models.Image{}, err
+		return models.Image{}, translate(err)
+	}
+
+	return image, nil
+}
+
+func (r *ImageRepository) GetRendition(ctx context.Context, parentID uuid.UUID, name string) (image models.Image, err error) {
+	err = r.conn.WithContext(ctx).Where("parent_id = ? AND rendition = ?", parentID, name).First(&image).Error
+	if err != nil {
+		return models.Image{}, translate(err)
 	}
 
 	return image, nil
 }
 
-func (r *ImageRepository) Delete(ctx context.Context, ids []uuid.UUID) error {
+func (r *ImageRepository) Renditions(ctx context.Context, parentID uuid.UUID) (images []models.Image, err error) {
+	err = r.conn.WithContext(ctx).Where("parent_id = ?", parentID).Order("rendition").Find(&images).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	return images, nil
+}
+
+func (r *ImageRepository) Pending(ctx context.Context, limit int) (images []models.Image, err error) {
+	err = r.conn.WithContext(ctx).Where("parent_id IS NOT NULL AND status = ?", models.STATUS_PENDING).
+		Order("created_at").Limit(limit).Find(&images).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	return images, nil
+}
+
+func (r *ImageRepository) Delete(ctx context.Context, ids []uuid.UUID) (deleted []uuid.UUID, err error) {
 	if len(ids) == 0 {
-		return nil
+		return nil, nil
+	}
+
+	err = r.conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// images already in the trash aren't found
+		var images []models.Image
+		err := tx.Select("id", "owner_id", "size").Where("id IN ? AND parent_id IS NULL", ids).Find(&images).Error
+		if err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			return nil
+		}
+
+		for _, image := range images {
+			deleted = append(deleted, image.ID)
+		}
+		if err := tx.Where("id IN ? OR parent_id IN ?", deleted, deleted).Delete(&models.Image{}).Error; err != nil {
+			return err
+		}
+
+		// images in the trash don't count, restoring them counts them again
+		return addImagesUsage(tx, images, -1)
+	})
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	return deleted, nil
+}
+
+func (r *ImageRepository) Restore(ctx context.Context, ids []uuid.UUID) (restored []uuid.UUID, err error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	err = r.conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var images []models.Image
+		// purged images are waited for, so they aren't counted again
+		err := tx.Unscoped().Select("id", "owner_id", "size").Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ? AND parent_id IS NULL AND deleted_at IS NOT NULL", ids).Find(&images).Error
+		if err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			return nil
+		}
+
+		for _, image := range images {
+			restored = append(restored, image.ID)
+		}
+		err = tx.Unscoped().Model(&models.Image{}).
+			Where("id IN ? OR parent_id IN ?", restored, restored).Update("deleted_at", nil).Error
+		if err != nil {
+			return err
+		}
+
+		return addImagesUsage(tx, images, 1)
+	})
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	return restored, nil
+}
+
+func (r *ImageRepository) Trashed(ctx context.Context, before time.Time, limit int) (ids []uuid.UUID, err error) {
+	err = r.conn.WithContext(ctx).Unscoped().Model(&models.Image{}).
+		Where("deleted_at < ? AND parent_id IS NULL", before).Order("deleted_at").Limit(limit).Pluck("id", &ids).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	return ids, nil
+}
+
+func (r *ImageRepository) Purge(ctx context.Context, ids []uuid.UUID, before time.Time) (purged []uuid.UUID, released []string, err error) {
+	if len(ids) == 0 {
+		return nil, nil, nil
+	}
+
+	err = r.conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// images restored since they were listed are left alone, the lock keeps them
+		// from being restored until they're gone
+		err := tx.Unscoped().Model(&models.Image{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ? AND parent_id IS NULL AND deleted_at IS NOT NULL AND deleted_at < ?", ids, before).
+			Pluck("id", &purged).Error
+		if err != nil || len(purged) == 0 {
+			return err
+		}
+
+		// images in the trash were released when they were deleted
+		released, err = releaseImages(tx.Unscoped().Session(&gorm.Session{}), purged)
+		return err
+	})
+	if err != nil {
+		return nil, nil, translate(err)
+	}
+
+	return purged, released, nil
+}
+
+func (r *ImageRepository) Writable(ctx context.Context, ids []uuid.UUID, viewer repositories.Viewer) (writable []uuid.UUID, err error) {
+	if len(ids) == 0 || (viewer.Subject == "" && !viewer.Admin) {
+		return nil, nil
+	}
+
+	tx := r.conn.WithContext(ctx).Unscoped().Model(&models.Image{}).Where("id IN ?", ids)
+	if !viewer.Admin {
+		tx = tx.Where(r.conn.Where("owner_id = ?", viewer.Subject).
+			Or("EXISTS (SELECT 1 FROM image_grants WHERE image_grants.image_id = images.id AND subject = ? AND permission = ?)",
+				viewer.Subject, models.PERMISSION_WRITE))
+	}
+
+	if err := tx.Pluck("id", &writable).Error; err != nil {
+		return nil, translate(err)
 	}
 
-	return r.conn.WithContext(ctx).Where("id IN (?)", ids).Delete(&models.Image{}).Error
-}
\ No newline at end of file
+	return writable, nil
+}
+
+func (r *ImageRepository) Permission(ctx context.Context, id uuid.UUID, subject string) (string, error) {
+	var grants []models.ImageGrant
+
+	err := r.conn.WithContext(ctx).Where("image_id = ? AND subject = ?", id, subject).Limit(1).Find(&grants).Error
+	if err != nil || len(grants) == 0 {
+		return "", translate(err)
+	}
+
+	return grants[0].Permission, nil
+}
+
+func (r *ImageRepository) SetVisibility(ctx context.Context, id uuid.UUID, visibility string) error {
+	tx := r.conn.WithContext(ctx).Model(&models.Image{}).Where("id = ?", id).Update("visibility", visibility)
+	if tx.Error != nil {
+		return translate(tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return repositories.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *ImageRepository) Grants(ctx context.Context, id uuid.UUID) (grants []models.ImageGrant, err error) {
+	err = r.conn.WithContext(ctx).Where("image_id = ?", id).Order("created_at").Find(&grants).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	return grants, nil
+}
+
+func (r *ImageRepository) Grant(ctx context.Context, grant models.ImageGrant) (models.ImageGrant, error) {
+	err := r.conn.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "image_id"}, {Name: "subject"}},
+		DoUpdates: clause.AssignmentColumns([]string{"permission"}),
+	}, clause.Returning{}).Create(&grant).Error
+	if err != nil {
+		return models.ImageGrant{}, translate(err)
+	}
+
+	return grant, nil
+}
+
+func (r *ImageRepository) Revoke(ctx context.Context, id uuid.UUID, subject string) (bool, error) {
+	tx := r.conn.WithContext(ctx).Where("image_id = ? AND subject = ?", id, subject).Delete(&models.ImageGrant{})
+	if tx.Error != nil {
+		return false, translate(tx.Error)
+	}
+
+	return tx.RowsAffected > 0, nil
+}
+
+func (r *ImageRepository) Stats(ctx context.Context) (stats repositories.ImageStats, err error) {
+	err = r.conn.WithContext(ctx).Unscoped().Model(&models.Image{}).Select(
+		`count(*) FILTER (WHERE deleted_at IS NULL) AS images,
+		COALESCE(sum(size) FILTER (WHERE deleted_at IS NULL), 0) AS bytes,
+		count(*) FILTER (WHERE deleted_at IS NOT NULL) AS trashed,
+		COALESCE(sum(size) FILTER (WHERE deleted_at IS NOT NULL), 0) AS trashed_bytes`).
+		Where("parent_id IS NULL").Scan(&stats).Error
+	if err != nil {
+		return repositories.ImageStats{}, translate(err)
+	}
+
+	return stats, nil
+}
+
+// visibleTo selects public images with the ones owned by or shared with the subject,
+// unlisted images are only found by their id
+func visibleTo(conn *gorm.DB, subject string) *gorm.DB {
+	visible := conn.Where("visibility = ?", models.VISIBILITY_PUBLIC)
+	if subject == "" {
+		return visible
+	}
+
+	return visible.Or("owner_id = ?", subject).
+		Or("EXISTS (SELECT 1 FROM image_grants WHERE image_grants.image_id = images.id AND subject = ?)", subject)
+}
+
+// releaseImages deletes the trashed images and drops their blob references,
+// returning storage keys of blobs nobody points at anymore
+func releaseImages(tx *gorm.DB, ids []uuid.UUID) (released []string, err error) {
+	var images []models.Image
+	err = tx.Select("id", "digest", "storage_key").
+		Where("(id IN ? OR parent_id IN ?) AND deleted_at IS NOT NULL", ids, ids).Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Where("(id IN ? OR parent_id IN ?) AND deleted_at IS NOT NULL", ids, ids).Delete(&models.Image{}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Where("image_id IN ?", ids).Delete(&models.ImageGrant{}).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Where("image_id IN ?", ids).Delete(&models.ShareLink{}).Error; err != nil {
+		return nil, err
+	}
+
+	refs := make(map[string]int64)
+	for _, image := range images {
+		if image.Digest == "" {
+			// stored before deduplication, the key belongs to this image only
+			if image.StorageKey != "" {
+				released = append(released, image.StorageKey)
+			}
+			continue
+		}
+		refs[image.Digest]++
+	}
+
+	digests := make([]string, 0, len(refs))
+	for digest, count := range refs {
+		err := tx.Model(&models.Blob{}).Where("digest = ?", digest).
+			Update("ref_count", gorm.Expr("ref_count - ?", count)).Error
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, digest)
+	}
+
+	if len(digests) == 0 {
+		return released, nil
+	}
+
+	var blobs []models.Blob
+	err = tx.Clauses(clause.Returning{Columns: []clause.Column{{Name: "storage_key"}}}).
+		Where("digest IN ? AND ref_count <= 0", digests).Delete(&blobs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, blob := range blobs {
+		released = append(released, blob.StorageKey)
+	}
+
+	return released, nil
+}
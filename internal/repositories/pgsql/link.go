@@ -0,0 +1,68 @@
+package pgsql
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"testapp/internal/models"
+)
+
+func NewLinkRepository(conn *gorm.DB) *LinkRepository {
+	return &LinkRepository{conn: conn}
+}
+
+type LinkRepository struct {
+	conn *gorm.DB
+}
+
+func (r *LinkRepository) Create(ctx context.Context, link models.ShareLink) (models.ShareLink, error) {
+	if err := r.conn.WithContext(ctx).Create(&link).Error; err != nil {
+		return models.ShareLink{}, translate(err)
+	}
+
+	return link, nil
+}
+
+func (r *LinkRepository) Get(ctx context.Context, id uuid.UUID) (link models.ShareLink, err error) {
+	err = r.conn.WithContext(ctx).Where("id = ?", id).First(&link).Error
+	if err != nil {
+		return models.ShareLink{}, translate(err)
+	}
+
+	return link, nil
+}
+
+func (r *LinkRepository) List(ctx context.Context, imageID uuid.UUID) (links []models.ShareLink, err error) {
+	err = r.conn.WithContext(ctx).Where("image_id = ?", imageID).Order("created_at").Find(&links).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	return links, nil
+}
+
+func (r *LinkRepository) Revoke(ctx context.Context, imageID, id uuid.UUID) (bool, error) {
+	tx := r.conn.WithContext(ctx).Model(&models.ShareLink{}).
+		Where("id = ? AND image_id = ? AND revoked_at IS NULL", id, imageID).
+		Update("revoked_at", time.Now())
+	if tx.Error != nil {
+		return false, translate(tx.Error)
+	}
+
+	return tx.RowsAffected > 0, nil
+}
+
+func (r *LinkRepository) Use(ctx context.Context, id uuid.UUID) (bool, error) {
+	tx := r.conn.WithContext(ctx).Model(&models.ShareLink{}).
+		Where("id = ? AND revoked_at IS NULL AND expires_at > ?", id, time.Now()).
+		Where("max_downloads = 0 OR downloads < max_downloads").
+		Update("downloads", gorm.Expr("downloads + 1"))
+	if tx.Error != nil {
+		return false, translate(tx.Error)
+	}
+
+	return tx.RowsAffected > 0, nil
+}
@@ -0,0 +1,153 @@
+package pgsql
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"testapp/internal/models"
+	"testapp/pkg/storage"
+)
+
+// migration records a step that changed what older versions stored, so it's run once
+type migration struct {
+	Name      string `gorm:"type:varchar(255);primaryKey"`
+	AppliedAt time.Time
+}
+
+func (migration) TableName() string {
+	return "schema_migrations"
+}
+
+type step struct {
+	name string
+	run  func(tx *gorm.DB, store storage.BlobStore) error
+}
+
+// steps are run in order after the tables are migrated, each in a transaction of its own
+var steps = []step{
+	// images stored before they had owners were listed to everyone, nobody owns them
+	// and they are kept public rather than hidden from everyone but admins
+	{name: "legacy_images_visibility", run: func(tx *gorm.DB, _ storage.BlobStore) error {
+		return tx.Unscoped().Model(&models.Image{}).
+			Where("parent_id IS NULL AND (owner_id IS NULL OR owner_id = '')").
+			Update("visibility", models.VISIBILITY_PUBLIC).Error
+	}},
+}
+
+// Migrate brings the tables up to date and moves what older versions stored to where it's stored now.
+// Keys of moved content are made by blobKey, like the keys of the images stored since.
+func Migrate(db *gorm.DB, store storage.BlobStore, blobKey func(name string) string) error {
+	if err := db.AutoMigrate(&migration{}); err != nil {
+		return err
+	}
+
+	// steps run before the tables are migrated, which can't be with what older versions stored
+	early := []step{
+		// images held their content in a bytea column, it's moved to the store
+		// before storage_key and size become required
+		{name: "image_content_to_store", run: func(tx *gorm.DB, store storage.BlobStore) error {
+			return moveImageContent(tx, store, blobKey)
+		}},
+	}
+	if err := apply(db, store, early); err != nil {
+		return err
+	}
+
+	err := db.AutoMigrate(&models.Image{}, &models.Blob{}, &models.Upload{}, &models.File{},
+		&models.ImageGrant{}, &models.ShareLink{}, &models.Usage{})
+	if err != nil {
+		return err
+	}
+
+	return apply(db, store, steps)
+}
+
+// apply runs the steps that weren't run yet
+func apply(db *gorm.DB, store storage.BlobStore, steps []step) error {
+	for _, step := range steps {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var applied int64
+			if err := tx.Model(&migration{}).Where("name = ?", step.name).Count(&applied).Error; err != nil {
+				return err
+			}
+			if applied > 0 {
+				return nil
+			}
+
+			if err := step.run(tx, store); err != nil {
+				return err
+			}
+
+			return tx.Create(&migration{Name: step.name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migrating %s: %w", step.name, err)
+		}
+	}
+
+	return nil
+}
+
+// moveImageContent puts the content of each image into the store, one image at a time,
+// and points the image at it. The content column is dropped once it's emptied.
+func moveImageContent(tx *gorm.DB, store storage.BlobStore, blobKey func(name string) string) error {
+	if !tx.Migrator().HasColumn("images", "content") {
+		return nil
+	}
+
+	for _, statement := range []string{
+		"ALTER TABLE images ADD COLUMN IF NOT EXISTS storage_key varchar(255)",
+		"ALTER TABLE images ADD COLUMN IF NOT EXISTS digest char(64)",
+		"ALTER TABLE images ADD COLUMN IF NOT EXISTS size bigint",
+		"ALTER TABLE images ALTER COLUMN content DROP NOT NULL",
+	} {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.AutoMigrate(&models.Blob{}); err != nil {
+		return err
+	}
+
+	var ids []uuid.UUID
+	if err := tx.Table("images").Where("content IS NOT NULL").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		var content []byte
+		if err := tx.Table("images").Select("content").Where("id = ?", id).Row().Scan(&content); err != nil {
+			return err
+		}
+
+		info, err := store.Put(tx.Statement.Context, blobKey(id.String()), bytes.NewReader(content))
+		if err != nil {
+			return err
+		}
+
+		digest := sha256.Sum256(content)
+		image := models.Image{ID: id, Digest: hex.EncodeToString(digest[:]), StorageKey: info.Key, Size: info.Size}
+		if err := takeBlob(tx, &image); err != nil {
+			return err
+		}
+		// identical content moved before is stored once
+		if image.StorageKey != info.Key {
+			store.Delete(tx.Statement.Context, info.Key)
+		}
+
+		err = tx.Table("images").Where("id = ?", id).Updates(map[string]interface{}{
+			"storage_key": image.StorageKey, "digest": image.Digest, "size": image.Size, "content": nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Migrator().DropColumn("images", "content")
+}
@@ -0,0 +1,49 @@
+package pgsql
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"testapp/internal/models"
+)
+
+func NewUploadRepository(conn *gorm.DB) *UploadRepository {
+	return &UploadRepository{conn: conn}
+}
+
+type UploadRepository struct {
+	conn *gorm.DB
+}
+
+func (r *UploadRepository) Create(ctx context.Context, upload models.Upload) error {
+	return translate(r.conn.WithContext(ctx).Create(&upload).Error)
+}
+
+func (r *UploadRepository) Get(ctx context.Context, id uuid.UUID) (upload models.Upload, err error) {
+	err = r.conn.WithContext(ctx).Where("id = ?", id).First(&upload).Error
+	if err != nil {
+		return models.Upload{}, translate(err)
+	}
+
+	return upload, nil
+}
+
+func (r *UploadRepository) Update(ctx context.Context, upload models.Upload) error {
+	return translate(r.conn.WithContext(ctx).Model(&upload).Select("upload_offset", "image_id").Updates(&upload).Error)
+}
+
+func (r *UploadRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return translate(r.conn.WithContext(ctx).Where("id = ?", id).Delete(&models.Upload{}).Error)
+}
+
+func (r *UploadRepository) Expired(ctx context.Context, now time.Time) (uploads []models.Upload, err error) {
+	err = r.conn.WithContext(ctx).Where("expires_at < ?", now).Find(&uploads).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	return uploads, nil
+}
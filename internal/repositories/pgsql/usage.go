@@ -0,0 +1,118 @@
+package pgsql
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"testapp/internal/models"
+)
+
+func NewUsageRepository(conn *gorm.DB) *UsageRepository {
+	return &UsageRepository{conn: conn}
+}
+
+type UsageRepository struct {
+	conn *gorm.DB
+}
+
+func (r *UsageRepository) Get(ctx context.Context, subject string) (models.Usage, error) {
+	var usages []models.Usage
+
+	err := r.conn.WithContext(ctx).Where("subject = ?", subject).Limit(1).Find(&usages).Error
+	if err != nil {
+		return models.Usage{}, translate(err)
+	}
+	if len(usages) == 0 {
+		return models.Usage{Subject: subject}, nil
+	}
+
+	return usages[0], nil
+}
+
+func (r *UsageRepository) Total(ctx context.Context) (total models.Usage, err error) {
+	err = r.conn.WithContext(ctx).Model(&models.Usage{}).
+		Select("COALESCE(SUM(bytes), 0) AS bytes, COALESCE(SUM(objects), 0) AS objects, MAX(updated_at) AS updated_at").
+		Scan(&total).Error
+	if err != nil {
+		return models.Usage{}, translate(err)
+	}
+
+	return total, nil
+}
+
+func (r *UsageRepository) List(ctx context.Context) (usages []models.Usage, err error) {
+	err = r.conn.WithContext(ctx).Order("bytes DESC, subject").Find(&usages).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	return usages, nil
+}
+
+func (r *UsageRepository) Recount(ctx context.Context) error {
+	return translate(r.conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM usages").Error; err != nil {
+			return err
+		}
+
+		return tx.Exec(`INSERT INTO usages (subject, bytes, objects, updated_at)
+			SELECT owner, SUM(size), COUNT(*), ? FROM (
+				SELECT COALESCE(owner_id, '') AS owner, size FROM images WHERE parent_id IS NULL AND deleted_at IS NULL
+				UNION ALL
+				SELECT COALESCE(owner_id, ''), size FROM files WHERE COALESCE(digest, '') <> ''
+			) stored GROUP BY owner`, time.Now()).Error
+	}))
+}
+
+// addUsage counts bytes and objects for the subject in the transaction
+// storing or deleting them, negative values release them
+func addUsage(tx *gorm.DB, subject string, bytes, objects int64) error {
+	if bytes == 0 && objects == 0 {
+		return nil
+	}
+
+	usage := models.Usage{Subject: subject, Bytes: bytes, Objects: objects}
+
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "subject"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"bytes":      gorm.Expr("usages.bytes + ?", bytes),
+			"objects":    gorm.Expr("usages.objects + ?", objects),
+			"updated_at": gorm.Expr("excluded.updated_at"),
+		}),
+	}).Create(&usage).Error
+}
+
+// addImagesUsage counts the original images among images for their owners
+func addImagesUsage(tx *gorm.DB, images []models.Image, sign int64) error {
+	type usage struct{ bytes, objects int64 }
+
+	owners := make(map[string]usage)
+	var order []string
+	for _, image := range images {
+		if image.ParentID != nil {
+			continue
+		}
+
+		u, ok := owners[image.OwnerID]
+		if !ok {
+			order = append(order, image.OwnerID)
+		}
+		owners[image.OwnerID] = usage{bytes: u.bytes + image.Size, objects: u.objects + 1}
+	}
+
+	// sorted, so concurrent transactions lock the rows in the same order
+	slices.Sort(order)
+	for _, owner := range order {
+		u := owners[owner]
+		if err := addUsage(tx, owner, sign*u.bytes, sign*u.objects); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
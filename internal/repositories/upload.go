@@ -0,0 +1,18 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"testapp/internal/models"
+)
+
+type UploadRepository interface {
+	Create(ctx context.Context, upload models.Upload) error
+	Get(ctx context.Context, id uuid.UUID) (models.Upload, error)
+	Update(ctx context.Context, upload models.Upload) error
+	Delete(ctx context.Context, id uuid.UUID) error
+	Expired(ctx context.Context, now time.Time) ([]models.Upload, error)
+}
@@ -0,0 +1,19 @@
+package repositories
+
+import (
+	"context"
+
+	"testapp/internal/models"
+)
+
+// UsageRepository reads usage, it's kept up to date by the repositories
+// creating and deleting images and files
+type UsageRepository interface {
+	// Get returns usage of the subject, it's zero when nothing is stored.
+	Get(ctx context.Context, subject string) (models.Usage, error)
+	// Total sums usage of all subjects.
+	Total(ctx context.Context) (models.Usage, error)
+	List(ctx context.Context) ([]models.Usage, error)
+	// Recount rebuilds usage from the stored images and files.
+	Recount(ctx context.Context) error
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+
+	"testapp/internal/models"
+	"testapp/internal/repositories"
+	pkgHTTP "testapp/pkg/http"
+)
+
+var (
+	ErrPermissionDenied  = errors.New("only the owner can change who sees the image")
+	ErrGrantNotFound     = errors.New("the image isn't shared with the subject")
+	ErrInvalidVisibility = errors.New("invalid visibility, expected private, unlisted or public")
+	ErrInvalidPermission = errors.New("invalid permission, expected read or write")
+	ErrInvalidSubject    = errors.New("invalid subject")
+)
+
+// viewer is who the request is made by, requests without a principal are anonymous
+func viewer(ctx context.Context) repositories.Viewer {
+	principal, _ := pkgHTTP.PrincipalFrom(ctx)
+	return repositories.Viewer{Subject: principal.Subject, Admin: principal.HasRole(pkgHTTP.ROLE_ADMIN)}
+}
+
+// permission returns what the viewer may do with the image, empty when it can't see it
+func (s *ImageService) permission(ctx context.Context, image models.Image) (string, error) {
+	v := viewer(ctx)
+	if v.Admin || (v.Subject != "" && image.OwnerID == v.Subject) {
+		return models.PERMISSION_WRITE, nil
+	}
+
+	if v.Subject != "" {
+		granted, err := s.rep.Permission(ctx, image.ID, v.Subject)
+		if err != nil || granted != "" {
+			return granted, err
+		}
+	}
+
+	if image.Visibility == models.VISIBILITY_PUBLIC || image.Visibility == models.VISIBILITY_UNLISTED {
+		return models.PERMISSION_READ, nil
+	}
+
+	return "", nil
+}
+
+// owned returns the image when the viewer owns it. Images it can't see aren't found,
+// so their ids don't leak.
+func (s *ImageService) owned(ctx context.Context, id uuid.UUID) (models.Image, error) {
+	image, err := s.Get(ctx, id)
+	if err != nil {
+		return models.Image{}, err
+	}
+
+	// images nobody owns, stored before images had owners, are changed by admins only
+	v := viewer(ctx)
+	if !v.Admin && (image.OwnerID == "" || image.OwnerID != v.Subject) {
+		return models.Image{}, ErrPermissionDenied
+	}
+
+	return image, nil
+}
+
+func (s *ImageService) SetVisibility(ctx context.Context, id uuid.UUID, visibility string) (models.Image, error) {
+	if !models.ValidVisibility(visibility) {
+		return models.Image{}, ErrInvalidVisibility
+	}
+
+	image, err := s.owned(ctx, id)
+	if err != nil {
+		return models.Image{}, err
+	}
+
+	if err := s.rep.SetVisibility(ctx, id, visibility); err != nil {
+		return models.Image{}, err
+	}
+	image.Visibility = visibility
+
+	return image, nil
+}
+
+func (s *ImageService) Grants(ctx context.Context, id uuid.UUID) ([]models.ImageGrant, error) {
+	if _, err := s.owned(ctx, id); err != nil {
+		return nil, err
+	}
+
+	return s.rep.Grants(ctx, id)
+}
+
+// Grant shares the image with the subject, the owner keeps its own access
+func (s *ImageService) Grant(ctx context.Context, id uuid.UUID, subject, permission string) (models.ImageGrant, error) {
+	if !models.ValidPermission(permission) {
+		return models.ImageGrant{}, ErrInvalidPermission
+	}
+	if subject == "" {
+		return models.ImageGrant{}, ErrInvalidSubject
+	}
+
+	image, err := s.owned(ctx, id)
+	if err != nil {
+		return models.ImageGrant{}, err
+	}
+	if subject == image.OwnerID {
+		return models.ImageGrant{}, ErrInvalidSubject
+	}
+
+	return s.rep.Grant(ctx, models.NewImageGrant(id, subject, permission))
+}
+
+// Revoke stops sharing the image with the subject
+func (s *ImageService) Revoke(ctx context.Context, id uuid.UUID, subject string) error {
+	if _, err := s.owned(ctx, id); err != nil {
+		return err
+	}
+
+	revoked, err := s.rep.Revoke(ctx, id, subject)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrGrantNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"testapp/internal/models"
+)
+
+// batch modes
+const (
+	// BATCH_ATOMIC saves all files of a batch or none of them
+	BATCH_ATOMIC = "atomic"
+	// BATCH_BEST_EFFORT saves every file that can be saved
+	BATCH_BEST_EFFORT = "best_effort"
+
+	DEFAULT_BATCH_MODE = BATCH_BEST_EFFORT
+)
+
+var (
+	ErrInvalidBatchMode = errors.New("invalid batch mode, expected atomic or best_effort")
+	// ErrBatchAborted is reported for files of an atomic batch that weren't saved because of another file
+	ErrBatchAborted = errors.New("not saved, the batch was aborted")
+)
+
+// UploadResult reports what happened to one file of a batch
+type UploadResult struct {
+	Filename    string     `json:"filename"`
+	ID          *uuid.UUID `json:"id,omitempty"`
+	ContentType string     `json:"content_type,omitempty"`
+	Size        int64      `json:"size"`
+	Err         error      `json:"-"`
+	// Error and Code explain Err to clients, they're left to the caller
+	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
+}
+
+// Batch saves several images to db. Images of an atomic batch are only stored
+// when they're added and created together on Commit, in one transaction.
+type Batch struct {
+	serv    *ImageService
+	atomic  bool
+	results []UploadResult
+	// written images of an atomic batch, in the order of their results
+	pending []models.Image
+	err     error
+}
+
+func (s *ImageService) NewBatch(mode string) (*Batch, error) {
+	switch mode {
+	case "", DEFAULT_BATCH_MODE:
+		return &Batch{serv: s}, nil
+	case BATCH_ATOMIC:
+		return &Batch{serv: s, atomic: true}, nil
+	}
+
+	return nil, ErrInvalidBatchMode
+}
+
+func (b *Batch) Atomic() bool {
+	return b.atomic
+}
+
+// Add saves the image, or only stores its content in an atomic batch, and returns its error
+func (b *Batch) Add(ctx context.Context, filename, contentType string, content io.Reader) (err error) {
+	ctx, span := tracer.Start(ctx, "Batch.Add", trace.WithAttributes(attribute.String("file.name", filename), attribute.Bool("batch.atomic", b.atomic)))
+	defer func() { end(span, err) }()
+
+	result := UploadResult{Filename: filename}
+
+	var pending models.Usage
+	for _, image := range b.pending {
+		pending.Bytes += image.Size
+		pending.Objects++
+	}
+
+	image, err := b.serv.prepare(ctx, filename, contentType, content, pending)
+	result.ContentType = image.ContentType
+	result.Size = image.Size
+	if err == nil {
+		uploadBytes.WithLabelValues(UPLOAD_KIND_IMAGE).Add(float64(image.Size))
+	}
+
+	if err == nil && !b.atomic {
+		written := image.StorageKey
+		image, err = b.serv.rep.Create(ctx, image)
+		if err != nil {
+			b.serv.store.Delete(ctx, written)
+		} else {
+			b.serv.saved(ctx, written, image)
+			result.ID = &image.ID
+		}
+	}
+
+	if err != nil {
+		result.Err = err
+		if b.err == nil {
+			b.err = err
+		}
+	} else if b.atomic {
+		b.pending = append(b.pending, image)
+	}
+
+	b.results = append(b.results, result)
+	return err
+}
+
+// Commit creates the images of an atomic batch, unless one of them failed.
+// It returns the first error of the batch.
+func (b *Batch) Commit(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "Batch.Commit", trace.WithAttributes(attribute.Int("batch.pending", len(b.pending))))
+	defer func() { end(span, err) }()
+
+	if !b.atomic {
+		return b.err
+	}
+	if b.err != nil {
+		b.Abort(ctx, b.err)
+		return b.err
+	}
+	if len(b.pending) == 0 {
+		return nil
+	}
+
+	created, err := b.serv.rep.CreateAll(ctx, b.pending)
+	if err != nil {
+		b.Abort(ctx, err)
+		return err
+	}
+
+	// every result has an image, as none of them failed
+	for i, image := range created {
+		b.serv.saved(ctx, b.pending[i].StorageKey, image)
+		b.results[i].ID = &created[i].ID
+	}
+	b.pending = nil
+
+	return nil
+}
+
+// Abort drops the stored content of an atomic batch, files without an error
+// are reported as not saved because of err
+func (b *Batch) Abort(ctx context.Context, err error) {
+	if !b.atomic {
+		return
+	}
+	if b.err == nil {
+		b.err = err
+	}
+
+	for _, image := range b.pending {
+		b.serv.store.Delete(ctx, image.StorageKey)
+	}
+	b.pending = nil
+
+	for i := range b.results {
+		if b.results[i].Err == nil {
+			b.results[i].Err = ErrBatchAborted
+		}
+	}
+}
+
+// Results lists the files in the order they were added
+func (b *Batch) Results() []UploadResult {
+	return b.results
+}
+
+// Saved tells how many images were saved
+func (b *Batch) Saved() (n int) {
+	for _, result := range b.results {
+		if result.ID != nil {
+			n++
+		}
+	}
+
+	return n
+}
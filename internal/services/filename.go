@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"golang.org/x/text/unicode/norm"
+
+	"testapp/internal/models"
+	"testapp/internal/repositories"
+	"testapp/pkg/storage"
+)
+
+// collision policies, what happens to an upload named like a stored file
+const (
+	COLLISION_REJECT    = "reject"
+	COLLISION_OVERWRITE = "overwrite"
+	COLLISION_SUFFIX    = "suffix" // "name (1).ext", "name (2).ext" and so on
+	COLLISION_UUID      = "uuid"   // a random name keeping the extension
+
+	DEFAULT_COLLISION = COLLISION_SUFFIX
+
+	MAX_NAME_LEN = 255
+	// uploads don't look for a free name forever
+	MAX_SUFFIX = 1000
+
+	DEFAULT_NAME = "file"
+)
+
+var (
+	ErrFileExists       = errors.New("file already exists")
+	ErrInvalidCollision = errors.New("invalid collision policy")
+)
+
+func validCollision(policy string) bool {
+	return policy == COLLISION_REJECT || policy == COLLISION_OVERWRITE || policy == COLLISION_SUFFIX || policy == COLLISION_UUID
+}
+
+// SanitizeName turns a client supplied file name into a name that is safe to store:
+// directories are dropped, the name is NFC normalized, control and reserved characters
+// are replaced and it's cut to MAX_NAME_LEN bytes, keeping the extension.
+func SanitizeName(name string) string {
+	name = name[strings.LastIndexAny(name, `/\`)+1:]
+	name = norm.NFC.String(strings.ToValidUTF8(name, ""))
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsControl(r):
+			return -1
+		case r != ' ' && unicode.IsSpace(r), strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+
+	// leading dots hide files, trailing dots and spaces are dropped by some filesystems
+	name = strings.Trim(truncateName(name, MAX_NAME_LEN), ". ")
+	if name == "" {
+		return DEFAULT_NAME
+	}
+
+	if !ValidName(name) {
+		name = "_" + name
+	}
+
+	return name
+}
+
+// truncateName cuts the base of the name, so the extension stays
+func truncateName(name string, size int) string {
+	if len(name) <= size {
+		return name
+	}
+
+	ext := path.Ext(name)
+	if len(ext) >= size {
+		ext = ""
+	}
+
+	return cut(name[:len(name)-len(ext)], size-len(ext)) + ext
+}
+
+// cut shortens s to at most size bytes on a rune boundary
+func cut(s string, size int) string {
+	if len(s) <= size {
+		return s
+	}
+
+	s = s[:size]
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+
+	return s
+}
+
+// reserve records the file under a name that's free according to the collision policy,
+// the returned file has the name its content should be stored under
+func (s *ImageService) reserve(ctx context.Context, file models.File) (models.File, error) {
+	switch s.collision {
+	case COLLISION_OVERWRITE:
+		// the record is replaced once the content is, files of others aren't
+		existing, err := s.files.Get(ctx, file.Name)
+		if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+			return models.File{}, err
+		}
+		if err == nil && existing.OwnerID != file.OwnerID && !viewer(ctx).Admin {
+			return models.File{}, fmt.Errorf("%w: %s", ErrFileExists, file.Name)
+		}
+		return file, nil
+	case COLLISION_REJECT:
+		ok, err := s.take(ctx, file)
+		if err != nil {
+			return models.File{}, err
+		}
+		if !ok {
+			return models.File{}, fmt.Errorf("%w: %s", ErrFileExists, file.Name)
+		}
+		return file, nil
+	case COLLISION_SUFFIX:
+		name := file.Name
+		ext := path.Ext(name)
+		if len(ext) > MAX_NAME_LEN/2 {
+			ext = ""
+		}
+		base := strings.TrimSuffix(name, ext)
+
+		for i := 1; i <= MAX_SUFFIX; i++ {
+			ok, err := s.take(ctx, file)
+			if err != nil {
+				return models.File{}, err
+			}
+			if ok {
+				return file, nil
+			}
+
+			suffix := fmt.Sprintf(" (%d)", i)
+			file.Name = cut(base, MAX_NAME_LEN-len(ext)-len(suffix)) + suffix + ext
+		}
+		return models.File{}, fmt.Errorf("%w: %s", ErrFileExists, name)
+	case COLLISION_UUID:
+		ext := strings.ToLower(path.Ext(file.Name))
+		for {
+			file.Name = truncateName(uuid.New().String()+ext, MAX_NAME_LEN)
+			ok, err := s.take(ctx, file)
+			if err != nil || ok {
+				return file, err
+			}
+		}
+	}
+
+	return models.File{}, fmt.Errorf("%w: %q", ErrInvalidCollision, s.collision)
+}
+
+// take reserves the name unless a file is recorded or stored under it,
+// files stored before they were recorded count as well
+func (s *ImageService) take(ctx context.Context, file models.File) (bool, error) {
+	_, err := s.store.Stat(ctx, file.Name)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return false, err
+	}
+
+	return s.files.Reserve(ctx, file)
+}
@@ -1,77 +1,636 @@
 package services
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
 	"io"
+	"log"
 	"os"
-	"path/filepath"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"testapp/internal/models"
 	"testapp/internal/repositories"
+	"testapp/pkg/exif"
+	"testapp/pkg/imaging"
+	"testapp/pkg/storage"
+)
+
+const (
+	IMAGES_PREFIX = "images"
+	// images are purged in batches, so a large trash doesn't hold one long transaction
+	PURGE_BATCH_SIZE = 100
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported file type")
+	ErrTypeMismatch    = errors.New("declared file type doesn't match its content")
+	ErrInvalidName     = errors.New("invalid file name")
 )
 
 type ImageService struct {
-	rep repositories.ImageRepository
+	rep       repositories.ImageRepository
+	files     repositories.FileRepository
+	usage     repositories.UsageRepository
+	store     storage.BlobStore
+	allowed   map[string]bool
+	metadata  string
+	collision string
+	retention time.Duration
+	quota     imaging.QuotaConfig
+
+	onSave  []func(ctx context.Context, image models.Image) error
+	onPurge []func(ctx context.Context, id uuid.UUID) error
+}
+
+func NewImageService(rep repositories.ImageRepository, files repositories.FileRepository, usage repositories.UsageRepository, store storage.BlobStore, conf imaging.Config) (*ImageService, error) {
+	allowedTypes := conf.AllowedTypes
+	if len(allowedTypes) == 0 {
+		allowedTypes = imaging.DEFAULT_ALLOWED_TYPES
+	}
+
+	allowed := make(map[string]bool, len(allowedTypes))
+	for _, contentType := range allowedTypes {
+		allowed[imaging.NormalizeContentType(contentType)] = true
+	}
+
+	metadata := conf.Metadata
+	if metadata == "" {
+		metadata = exif.DEFAULT_POLICY
+	}
+	if !exif.ValidPolicy(metadata) {
+		return nil, fmt.Errorf("Metadata: %w: %q", exif.ErrInvalidPolicy, metadata)
+	}
+
+	collision := conf.Collision
+	if collision == "" {
+		collision = DEFAULT_COLLISION
+	}
+	// uploads would all fail with it
+	if !validCollision(collision) {
+		return nil, fmt.Errorf("Collision: %w: %q", ErrInvalidCollision, collision)
+	}
+
+	retention := conf.Retention
+	if retention <= 0 {
+		retention = imaging.DEFAULT_RETENTION
+	}
+
+	return &ImageService{
+		rep:       rep,
+		files:     files,
+		usage:     usage,
+		store:     store,
+		allowed:   allowed,
+		metadata:  metadata,
+		collision: collision,
+		retention: retention,
+		quota:     conf.Quota,
+	}, nil
 }
 
-func NewImageService(rep repositories.ImageRepository) *ImageService {
-	return &ImageService{rep:rep}
+// OnSave registers fn to be called after an image is saved to db
+func (s *ImageService) OnSave(fn func(ctx context.Context, image models.Image) error) {
+	s.onSave = append(s.onSave, fn)
+}
+
+// MaxSize returns the size of the largest content the store holds, 0 when there's no limit
+func (s *ImageService) MaxSize() int64 {
+	return storage.MaxSize(s.store)
+}
+
+// OnPurge registers fn to be called after an image is removed for good
+func (s *ImageService) OnPurge(fn func(ctx context.Context, id uuid.UUID) error) {
+	s.onPurge = append(s.onPurge, fn)
+}
+
+// SaveFile stores the file under its sanitized name, the collision policy decides
+// what happens when the name is taken. The name it was sent with is recorded as well.
+func (s *ImageService) SaveFile(ctx context.Context, filename, contentType string, content io.Reader) (_ models.File, err error) {
+	ctx, span := tracer.Start(ctx, "ImageService.SaveFile", trace.WithAttributes(attribute.String("file.name", filename)))
+	defer func() { end(span, err) }()
+
+	contentType, content, err = s.sniff(contentType, content)
+	if err != nil {
+		return models.File{}, err
+	}
+
+	scrubbed := s.scrub(contentType, content)
+	defer scrubbed.Close()
+
+	limited, err := s.allowance(ctx, scrubbed, models.Usage{})
+	if err != nil {
+		return models.File{}, err
+	}
+
+	file := models.NewFile(SanitizeName(filename), filename, contentType)
+	file.OwnerID = viewer(ctx).Subject
+	file, err = s.reserve(ctx, file)
+	if err != nil {
+		return models.File{}, err
+	}
+
+	hash := sha256.New()
+	info, err := s.store.Put(ctx, file.Name, io.TeeReader(limited, hash))
+	if err != nil {
+		// an overwritten file keeps its record, the store leaves its content as it was
+		if s.collision != COLLISION_OVERWRITE {
+			s.files.Delete(ctx, file.Name)
+		}
+		return models.File{}, err
+	}
+	uploadBytes.WithLabelValues(UPLOAD_KIND_FILE).Add(float64(info.Size))
+
+	file.Size = info.Size
+	file.Digest = hex.EncodeToString(hash.Sum(nil))
+	if err := s.files.Save(ctx, file); err != nil {
+		return models.File{}, err
+	}
+
+	return file, nil
 }
 
-func (s *ImageService) SaveFile(ctx context.Context, filename string, content io.Reader) error {
-	if !DirectoryExists(filepath.Join(".", "assets", "uploads")) {
-		return os.ErrNotExist
+func (s *ImageService) SaveFileToDB(ctx context.Context, filename, contentType string, content io.Reader) (_ models.Image, err error) {
+	ctx, span := tracer.Start(ctx, "ImageService.SaveFileToDB", trace.WithAttributes(attribute.String("file.name", filename)))
+	defer func() { end(span, err) }()
+
+	image, err := s.prepare(ctx, filename, contentType, content, models.Usage{})
+	if err != nil {
+		return models.Image{}, err
 	}
 
-	filename = filepath.Join(".", "assets", "uploads", filename)
-	newFile, err := os.Create(filename)
+	created, err := s.rep.Create(ctx, image)
 	if err != nil {
+		s.store.Delete(ctx, image.StorageKey)
+		return models.Image{}, err
+	}
+	s.saved(ctx, image.StorageKey, created)
+
+	return created, nil
+}
+
+// prepare stores the content of a new image, which is saved to db separately.
+// The image has its detected content type even when it's rejected.
+// Pending is what the request stored before, it counts towards the quotas.
+func (s *ImageService) prepare(ctx context.Context, filename, contentType string, content io.Reader, pending models.Usage) (_ models.Image, err error) {
+	ctx, span := tracer.Start(ctx, "ImageService.prepare")
+	defer func() { end(span, err) }()
+
+	contentType, content, err = s.sniff(contentType, content)
+	image := models.NewImage(uuid.New(), filename, contentType, "", "", 0)
+	image.OwnerID = viewer(ctx).Subject
+	if err != nil {
+		return image, err
+	}
+
+	scrubbed := s.scrub(contentType, content)
+	defer scrubbed.Close()
+
+	limited, err := s.allowance(ctx, scrubbed, pending)
+	if err != nil {
+		return image, err
+	}
+
+	return image, s.write(ctx, &image, limited)
+}
+
+// saved drops the written content when the image got an existing blob
+// and runs the hooks of saved images
+func (s *ImageService) saved(ctx context.Context, written string, image models.Image) {
+	s.settle(ctx, written, image)
+
+	// the image is saved at this point, so failing hooks don't fail the upload
+	for _, fn := range s.onSave {
+		if err := fn(ctx, image); err != nil {
+			log.Printf("Failed to process saved image %s: %v", image.ID, err)
+		}
+	}
+}
+
+// sniff detects the content type from the leading bytes instead of trusting the client,
+// checks it against the allowlist and returns a reader that still yields the whole content
+func (s *ImageService) sniff(declared string, content io.Reader) (string, io.Reader, error) {
+	buffered := bufio.NewReaderSize(content, imaging.SNIFF_LEN)
+
+	head, err := buffered.Peek(imaging.SNIFF_LEN)
+	if err != nil && err != io.EOF {
+		return "", nil, err
+	}
+
+	// the detected type is returned with errors too, so they can be reported
+	detected := imaging.DetectContentType(head)
+	if !s.allowed[detected] {
+		return detected, nil, fmt.Errorf("%w: %s", ErrUnsupportedType, detected)
+	}
+
+	declared = imaging.NormalizeContentType(declared)
+	if declared != "" && declared != detected {
+		return detected, nil, fmt.Errorf("%w: declared %s, detected %s", ErrTypeMismatch, declared, detected)
+	}
+
+	return detected, buffered, nil
+}
+
+// scrub removes the metadata the policy doesn't keep while the content is being written.
+// Images losing their orientation tag are rotated instead, so they still display upright.
+func (s *ImageService) scrub(contentType string, content io.Reader) io.ReadCloser {
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		if s.metadata != exif.STRIP_ALL || imaging.FormatOf(contentType) == "" {
+			writer.CloseWithError(exif.Scrub(writer, content, s.metadata))
+			return
+		}
+
+		// JPEG and PNG keep EXIF in front of the image data
+		buffered := bufio.NewReaderSize(content, 2*exif.MAX_SIZE)
+		head, err := buffered.Peek(2 * exif.MAX_SIZE)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			writer.CloseWithError(err)
+			return
+		}
+
+		orientation := 1
+		if raw, err := exif.Extract(bytes.NewReader(head)); err == nil {
+			if data, err := exif.Parse(raw); err == nil && data.Orientation > 1 {
+				orientation = data.Orientation
+			}
+		}
+
+		if orientation == 1 {
+			writer.CloseWithError(exif.Scrub(writer, buffered, s.metadata))
+			return
+		}
+
+		writer.CloseWithError(s.orient(writer, buffered, contentType, orientation))
+	}()
+
+	return pipeReader{reader, done}
+}
+
+// pipeReader waits for the goroutine writing into the pipe on Close,
+// so the content isn't read after its owner closed it
+type pipeReader struct {
+	*io.PipeReader
+	done chan struct{}
+}
+
+func (r pipeReader) Close() error {
+	r.PipeReader.Close()
+	<-r.done
+
+	return nil
+}
+
+// orient re-encodes the image with the orientation applied to its pixels,
+// encoders don't write any metadata. Images that can't be decoded are only scrubbed.
+func (s *ImageService) orient(w io.Writer, content io.Reader, contentType string, orientation int) error {
+	// the content is read twice when it can't be decoded, it's spooled to disk rather than memory
+	tmp, err := os.CreateTemp("", "orient-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, content); err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
 		return err
 	}
-	defer newFile.Close()
 
-	if _, err := io.Copy(newFile, content); err != nil {
+	img, err := imaging.Decode(tmp)
+	if err != nil {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return exif.Scrub(w, tmp, s.metadata)
+	}
+
+	return imaging.Encode(w, imaging.Orient(img, orientation), imaging.FormatOf(contentType), imaging.DefaultQuality)
+}
+
+// write stores the content under a key of its own and fills in the image's digest, size
+// and metadata. The content is hashed while it's being written, so it's never held in memory.
+// The span covers reading the request body, which is streamed into the store.
+func (s *ImageService) write(ctx context.Context, image *models.Image, content io.Reader) (err error) {
+	ctx, span := tracer.Start(ctx, "ImageService.write")
+	defer func() {
+		span.SetAttributes(attribute.Int64("image.size", image.Size))
+		end(span, err)
+	}()
+
+	hash := sha256.New()
+
+	info, err := s.store.Put(ctx, BlobKey(image.ID.String()), io.TeeReader(content, hash))
+	if err != nil {
+		return err
+	}
+
+	image.Digest = hex.EncodeToString(hash.Sum(nil))
+	image.StorageKey = info.Key
+	image.Size = info.Size
+
+	if err := s.describe(ctx, image); err != nil {
+		s.store.Delete(ctx, info.Key)
 		return err
 	}
 
 	return nil
 }
 
-func (s *ImageService) SaveFileToDB(ctx context.Context, contentType string, content io.Reader) error {
-	contentBytes, err := io.ReadAll(content)
+// describe extracts dimensions, color model, frame count and EXIF of the stored content.
+// Files that aren't decodable images, like PDFs, are left without them.
+func (s *ImageService) describe(ctx context.Context, image *models.Image) (err error) {
+	ctx, span := tracer.Start(ctx, "ImageService.describe")
+	defer func() { end(span, err) }()
+
+	content, err := s.Open(ctx, *image)
 	if err != nil {
 		return err
 	}
+	defer content.Close()
 
-	image := models.NewImage(uuid.New(), contentType, contentBytes)
+	metadata, err := imaging.ReadMetadata(content)
+	if err != nil {
+		return nil
+	}
 
-	if err = s.rep.Create(ctx, image); err != nil {
+	image.Width, image.Height = metadata.Width, metadata.Height
+	image.ColorModel, image.Frames = metadata.ColorModel, metadata.Frames
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
 		return err
 	}
 
+	if raw, err := exif.Extract(content); err == nil {
+		if data, err := exif.Parse(raw); err == nil {
+			image.Exif = &data
+		}
+	}
+
 	return nil
 }
 
+// settle drops the written copy when identical content was already stored
+func (s *ImageService) settle(ctx context.Context, written string, image models.Image) {
+	if image.StorageKey != written {
+		s.store.Delete(ctx, written)
+	}
+}
+
+// Get returns the image if the request's principal can see it,
+// other images aren't found as if they didn't exist
 func (s *ImageService) Get(ctx context.Context, id uuid.UUID) (models.Image, error) {
-	return s.rep.Get(ctx, id)
+	image, err := s.rep.Get(ctx, id)
+	if err != nil {
+		return models.Image{}, err
+	}
+
+	permission, err := s.permission(ctx, image)
+	if err != nil {
+		return models.Image{}, err
+	}
+	if permission == "" {
+		return models.Image{}, repositories.ErrNotFound
+	}
+
+	return image, nil
+}
+
+// Delete moves the images to the trash, they can be restored until the retention passes.
+// Only images the principal can change are deleted.
+func (s *ImageService) Delete(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error) {
+	writable, err := s.rep.Writable(ctx, ids, viewer(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.rep.Delete(ctx, writable)
+}
+
+func (s *ImageService) Restore(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error) {
+	writable, err := s.rep.Writable(ctx, ids, viewer(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.rep.Restore(ctx, writable)
+}
+
+// Purge removes the images trashed before the given time for good along with content
+// no other image points at
+func (s *ImageService) Purge(ctx context.Context, ids []uuid.UUID, before time.Time) error {
+	purged, released, err := s.rep.Purge(ctx, ids, before)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range released {
+		if err := s.store.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range purged {
+		for _, fn := range s.onPurge {
+			if err := fn(ctx, id); err != nil {
+				log.Printf("Failed to clean up after purged image %s: %v", id, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// PurgeTrash purges images deleted longer than the retention ago
+func (s *ImageService) PurgeTrash(ctx context.Context) error {
+	for {
+		before := time.Now().Add(-s.retention)
+		ids, err := s.rep.Trashed(ctx, before, PURGE_BATCH_SIZE)
+		if err != nil {
+			return err
+		}
+
+		if len(ids) == 0 {
+			return nil
+		}
+
+		if err := s.Purge(ctx, ids, before); err != nil {
+			return err
+		}
+
+		if len(ids) < PURGE_BATCH_SIZE {
+			return nil
+		}
+	}
 }
 
-func (s *ImageService) ReadFile(ctx context.Context, filename string) ([]byte, error) {
-	fileBytes, err := os.ReadFile(filepath.Join("assets", "tmp", filename))
+// Run purges the trash every interval until ctx is done
+func (s *ImageService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.PurgeTrash(ctx); err != nil {
+				log.Printf("Failed to purge deleted images: %v", err)
+			}
+		}
+	}
+}
+
+// Open returns the stored content, it's seekable so ranges of it can be served
+func (s *ImageService) Open(ctx context.Context, image models.Image) (io.ReadSeekCloser, error) {
+	content, err := s.store.Get(ctx, image.StorageKey)
 	if err != nil {
 		return nil, err
 	}
 
-	return fileBytes, nil
+	return seekable(content)
 }
 
-func DirectoryExists(path string) bool {
-	info, err := os.Stat(path)
-	if os.IsNotExist(err) {
+// decode reads the stored content as an image
+func (s *ImageService) decode(ctx context.Context, stored models.Image) (image.Image, error) {
+	content, err := s.Open(ctx, stored)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	return imaging.Decode(content)
+}
+
+// seekable returns the content as is when the store opened it seekable, otherwise it's read into memory
+func seekable(content io.ReadCloser) (io.ReadSeekCloser, error) {
+	if seeker, ok := content.(io.ReadSeekCloser); ok {
+		return seeker, nil
+	}
+	defer content.Close()
+
+	contentBytes, err := io.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return nopSeekCloser{bytes.NewReader(contentBytes)}, nil
+}
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error {
+	return nil
+}
+
+// File is a file stored by name
+type File struct {
+	io.ReadSeekCloser
+	Name        string // the name it was uploaded with when it's recorded
+	ContentType string
+	Digest      string
+	ModTime     time.Time
+}
+
+// OpenFile opens a file stored by name. Names are single path elements,
+// so they can't point outside of the files or at blobs of images.
+// Only the owner can open a file, files nobody owns, like those stored before
+// uploads were recorded, only admins. Others can't tell the file exists.
+func (s *ImageService) OpenFile(ctx context.Context, name string) (File, error) {
+	if !ValidName(name) {
+		return File{}, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+
+	record, err := s.files.Get(ctx, name)
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		return File{}, err
+	}
+
+	if v := viewer(ctx); !v.Admin && (record.OwnerID == "" || record.OwnerID != v.Subject) {
+		return File{}, storage.ErrNotFound
+	}
+
+	info, err := s.store.Stat(ctx, name)
+	if err != nil {
+		return File{}, err
+	}
+
+	stored, err := s.store.Get(ctx, name)
+	if err != nil {
+		return File{}, err
+	}
+
+	content, err := seekable(stored)
+	if err != nil {
+		return File{}, err
+	}
+
+	file := File{ReadSeekCloser: content, Name: name, ModTime: info.ModTime}
+	if record.OriginalName != "" {
+		file.Name = record.OriginalName
+	}
+	if record.Digest != "" {
+		file.ContentType, file.Digest = record.ContentType, record.Digest
+		return file, nil
+	}
+
+	// files stored before uploads were recorded have no record, their type and digest come from the content
+
+	head := make([]byte, imaging.SNIFF_LEN)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		content.Close()
+		return File{}, err
+	}
+	file.ContentType = imaging.DetectContentType(head[:n])
+
+	hash := sha256.New()
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		content.Close()
+		return File{}, err
+	}
+	if _, err := io.Copy(hash, content); err != nil {
+		content.Close()
+		return File{}, err
+	}
+	file.Digest = hex.EncodeToString(hash.Sum(nil))
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		content.Close()
+		return File{}, err
+	}
+
+	return file, nil
+}
+
+func ValidName(name string) bool {
+	if name == "" || len(name) > 255 || strings.HasPrefix(name, ".") || strings.ContainsAny(name, "/\\\x00") {
 		return false
 	}
 
-	return info.IsDir()
-}
\ No newline at end of file
+	// directories of images and resumable uploads
+	return name != IMAGES_PREFIX && name != UPLOADS_PREFIX && name != VARIANTS_PREFIX
+}
+
+func BlobKey(name string) string {
+	return path.Join(IMAGES_PREFIX, name[:2], name)
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"testapp/internal/models"
+	"testapp/internal/repositories"
+	pkgHTTP "testapp/pkg/http"
+	"testapp/pkg/imaging"
+)
+
+var (
+	ErrInvalidLink         = errors.New("invalid link")
+	ErrLinkNotFound        = errors.New("link not found")
+	ErrLinkExpired         = errors.New("the link expired")
+	ErrLinkRevoked         = errors.New("the link was revoked")
+	ErrLinkUsedUp          = errors.New("the link reached its download limit")
+	ErrRenditionNotAllowed = errors.New("the link doesn't allow the rendition")
+	ErrInvalidTTL          = errors.New("invalid link lifetime")
+	ErrInvalidMaxDownloads = errors.New("max downloads can't be negative")
+	ErrUnknownRendition    = errors.New("unknown rendition")
+)
+
+// LinkOptions limit what a share link can be used for, zero values are the defaults
+type LinkOptions struct {
+	TTL          time.Duration
+	MaxDownloads int
+	Renditions   []string
+}
+
+// LinkService issues share links for images. A link's token carries its id and expiry
+// signed with HMAC-SHA256, so forged and expired tokens are rejected without a query.
+type LinkService struct {
+	rep        repositories.LinkRepository
+	images     *ImageService
+	renditions []string
+	secret     []byte
+	ttl        time.Duration
+	maxTTL     time.Duration
+}
+
+// NewLinkService refuses secrets anyone could guess, links would be forged with them
+func NewLinkService(rep repositories.LinkRepository, images *ImageService, conf imaging.Config) (*LinkService, error) {
+	secret := []byte(conf.Links.Secret)
+	if len(secret) > 0 {
+		if err := pkgHTTP.CheckSecret(conf.Links.Secret); err != nil {
+			return nil, fmt.Errorf("Links.Secret: %w", err)
+		}
+	} else {
+		log.Println("No secret configured for share links, they are signed with a random key")
+
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err)
+		}
+	}
+
+	maxTTL := conf.Links.MaxTTL
+	if maxTTL <= 0 {
+		maxTTL = imaging.MAX_LINK_TTL
+	}
+
+	ttl := conf.Links.TTL
+	if ttl <= 0 {
+		ttl = imaging.DEFAULT_LINK_TTL
+	}
+	ttl = min(ttl, maxTTL)
+
+	renditions := []string{models.RENDITION_ORIGINAL}
+	for _, r := range conf.Renditions {
+		renditions = append(renditions, r.Name)
+	}
+
+	return &LinkService{
+		rep:        rep,
+		images:     images,
+		renditions: renditions,
+		secret:     secret,
+		ttl:        ttl,
+		maxTTL:     maxTTL,
+	}, nil
+}
+
+// Create issues a link to the image, only its owner can
+func (s *LinkService) Create(ctx context.Context, imageID uuid.UUID, options LinkOptions) (models.ShareLink, error) {
+	ttl := options.TTL
+	if ttl == 0 {
+		ttl = s.ttl
+	}
+	if ttl < 0 || ttl > s.maxTTL {
+		return models.ShareLink{}, ErrInvalidTTL
+	}
+
+	if options.MaxDownloads < 0 {
+		return models.ShareLink{}, ErrInvalidMaxDownloads
+	}
+
+	var renditions []string
+	for _, name := range options.Renditions {
+		if !slices.Contains(s.renditions, name) {
+			return models.ShareLink{}, ErrUnknownRendition
+		}
+		if !slices.Contains(renditions, name) {
+			renditions = append(renditions, name)
+		}
+	}
+
+	if _, err := s.images.owned(ctx, imageID); err != nil {
+		return models.ShareLink{}, err
+	}
+
+	// the expiry is signed in seconds, so it's stored without a fraction
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+	link := models.NewShareLink(uuid.New(), imageID, viewer(ctx).Subject, expiresAt, options.MaxDownloads, renditions)
+
+	return s.rep.Create(ctx, link)
+}
+
+func (s *LinkService) List(ctx context.Context, imageID uuid.UUID) ([]models.ShareLink, error) {
+	if _, err := s.images.owned(ctx, imageID); err != nil {
+		return nil, err
+	}
+
+	return s.rep.List(ctx, imageID)
+}
+
+// Revoke makes the link unusable before it expires
+func (s *LinkService) Revoke(ctx context.Context, imageID, id uuid.UUID) error {
+	if _, err := s.images.owned(ctx, imageID); err != nil {
+		return err
+	}
+
+	revoked, err := s.rep.Revoke(ctx, imageID, id)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrLinkNotFound
+	}
+
+	return nil
+}
+
+// Token is the signed token of the link, it's the same every time
+func (s *LinkService) Token(link models.ShareLink) string {
+	payload := make([]byte, 0, 24)
+	payload = append(payload, link.ID[:]...)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(link.ExpiresAt.Unix()))
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(s.sign(payload))
+}
+
+// Resolve returns the image or its rendition the token gives access to and its link.
+// The download isn't counted, partial and unchanged responses aren't downloads, see Count.
+func (s *LinkService) Resolve(ctx context.Context, token, rendition string) (models.Image, models.ShareLink, error) {
+	id, expiresAt, err := s.verify(token)
+	if err != nil {
+		return models.Image{}, models.ShareLink{}, err
+	}
+	if time.Now().After(expiresAt) {
+		return models.Image{}, models.ShareLink{}, ErrLinkExpired
+	}
+
+	link, err := s.rep.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return models.Image{}, models.ShareLink{}, ErrInvalidLink
+		}
+		return models.Image{}, models.ShareLink{}, err
+	}
+	switch {
+	case !link.ExpiresAt.Equal(expiresAt):
+		return models.Image{}, models.ShareLink{}, ErrInvalidLink
+	case link.RevokedAt != nil:
+		return models.Image{}, models.ShareLink{}, ErrLinkRevoked
+	case !link.Allows(rendition):
+		return models.Image{}, models.ShareLink{}, ErrRenditionNotAllowed
+	case link.MaxDownloads > 0 && link.Downloads >= link.MaxDownloads:
+		return models.Image{}, models.ShareLink{}, ErrLinkUsedUp
+	}
+
+	// the link stands in for the owner, so the image is read regardless of who asks
+	var image models.Image
+	if rendition == models.RENDITION_ORIGINAL {
+		image, err = s.images.rep.Get(ctx, link.ImageID)
+	} else {
+		if _, err = s.images.rep.Get(ctx, link.ImageID); err == nil {
+			image, err = s.images.rep.GetRendition(ctx, link.ImageID, rendition)
+		}
+	}
+	if err != nil {
+		return models.Image{}, models.ShareLink{}, err
+	}
+
+	return image, link, nil
+}
+
+// Count counts a download of the image served in full with the link
+func (s *LinkService) Count(ctx context.Context, link models.ShareLink) error {
+	used, err := s.rep.Use(ctx, link.ID)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrLinkUsedUp
+	}
+
+	return nil
+}
+
+func (s *LinkService) verify(token string) (uuid.UUID, time.Time, error) {
+	encodedPayload, encodedMAC, ok := strings.Cut(token, ".")
+	if !ok {
+		return uuid.Nil, time.Time{}, ErrInvalidLink
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil || len(payload) != 24 {
+		return uuid.Nil, time.Time{}, ErrInvalidLink
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, s.sign(payload)) {
+		return uuid.Nil, time.Time{}, ErrInvalidLink
+	}
+
+	id, err := uuid.FromBytes(payload[:16])
+	if err != nil {
+		return uuid.Nil, time.Time{}, ErrInvalidLink
+	}
+
+	return id, time.Unix(int64(binary.BigEndian.Uint64(payload[16:])), 0), nil
+}
+
+func (s *LinkService) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(payload)
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"testapp/internal/models"
+	"testapp/internal/repositories"
+	"testapp/pkg/imaging"
+)
+
+const (
+	DEFAULT_PAGE_SIZE = 50
+	MAX_PAGE_SIZE     = 1000
+	DEFAULT_SORT      = "-" + repositories.SORT_CREATED_AT
+)
+
+var (
+	ErrInvalidSort   = errors.New("invalid sort, expected created_at or size with an optional - prefix")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+type ListQuery struct {
+	Limit int
+	// created_at or size, "-" in front sorts in descending order
+	Sort         string
+	ContentTypes []string
+	Cursor       string
+}
+
+// cursor is the position after the last image of a page
+type cursor struct {
+	Sort      string    `json:"s"`
+	CreatedAt time.Time `json:"c"`
+	Size      int64     `json:"n"`
+	ID        uuid.UUID `json:"id"`
+}
+
+// List returns a page of images and the cursor of the next one, which is empty on the last page
+func (s *ImageService) List(ctx context.Context, q ListQuery) ([]models.Image, string, error) {
+	sort := q.Sort
+	if sort == "" {
+		sort = DEFAULT_SORT
+	}
+
+	query := repositories.PageQuery{
+		Viewer: viewer(ctx),
+		Limit:  min(q.Limit, MAX_PAGE_SIZE),
+		Sort:   strings.TrimPrefix(sort, "-"),
+		Desc:   strings.HasPrefix(sort, "-"),
+	}
+	if query.Limit <= 0 {
+		query.Limit = DEFAULT_PAGE_SIZE
+	}
+	if query.Sort != repositories.SORT_CREATED_AT && query.Sort != repositories.SORT_SIZE {
+		return nil, "", ErrInvalidSort
+	}
+
+	for _, contentType := range q.ContentTypes {
+		if contentType = imaging.NormalizeContentType(contentType); contentType != "" {
+			query.ContentTypes = append(query.ContentTypes, contentType)
+		}
+	}
+
+	if q.Cursor != "" {
+		after, err := decodeCursor(q.Cursor)
+		// a cursor is only valid for the order it was made for
+		if err != nil || after.Sort != sort {
+			return nil, "", ErrInvalidCursor
+		}
+		query.After = &models.Image{ID: after.ID, CreatedAt: after.CreatedAt, Size: after.Size}
+	}
+
+	// one more image tells whether there is a next page
+	limit := query.Limit
+	query.Limit++
+
+	images, err := s.rep.Paginate(ctx, query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(images) <= limit {
+		return images, "", nil
+	}
+
+	images = images[:limit]
+	last := images[limit-1]
+
+	return images, encodeCursor(cursor{Sort: sort, CreatedAt: last.CreatedAt, Size: last.Size, ID: last.ID}), nil
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor{}, err
+	}
+
+	var c cursor
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"testapp/internal/repositories"
+	"testapp/pkg/metrics"
+)
+
+// kinds of uploads, resumable ones are counted chunk by chunk as they arrive
+const (
+	UPLOAD_KIND_FILE      = "file"
+	UPLOAD_KIND_IMAGE     = "image"
+	UPLOAD_KIND_RESUMABLE = "resumable"
+)
+
+// STATS_TIMEOUT bounds the queries run when the metrics are scraped
+const STATS_TIMEOUT = 5 * time.Second
+
+var uploadBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.NAMESPACE,
+	Subsystem: "upload",
+	Name:      "bytes_total",
+	Help:      "Bytes stored by uploads, by kind of upload.",
+}, []string{"kind"})
+
+var (
+	imagesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.NAMESPACE, "", "images"),
+		"Images stored, live or in the trash.",
+		[]string{"state"}, nil,
+	)
+	imagesBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metrics.NAMESPACE, "", "images_bytes"),
+		"Bytes taken by the images stored, live or in the trash.",
+		[]string{"state"}, nil,
+	)
+)
+
+// Collector reports the number and size of the images stored when scraped
+func (s *ImageService) Collector() prometheus.Collector {
+	return imageCollector{rep: s.rep}
+}
+
+type imageCollector struct {
+	rep repositories.ImageRepository
+}
+
+func (c imageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- imagesDesc
+	ch <- imagesBytesDesc
+}
+
+func (c imageCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), STATS_TIMEOUT)
+	defer cancel()
+
+	stats, err := c.rep.Stats(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(imagesDesc, err)
+		ch <- prometheus.NewInvalidMetric(imagesBytesDesc, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(imagesDesc, prometheus.GaugeValue, float64(stats.Images), "live")
+	ch <- prometheus.MustNewConstMetric(imagesDesc, prometheus.GaugeValue, float64(stats.Trashed), "trashed")
+	ch <- prometheus.MustNewConstMetric(imagesBytesDesc, prometheus.GaugeValue, float64(stats.Bytes), "live")
+	ch <- prometheus.MustNewConstMetric(imagesBytesDesc, prometheus.GaugeValue, float64(stats.TrashedBytes), "trashed")
+}
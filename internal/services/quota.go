@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"testapp/internal/models"
+)
+
+var (
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+	ErrStorageFull   = errors.New("not enough storage left")
+)
+
+// Quota is what a user can store, zero limits are unlimited
+type Quota struct {
+	Bytes   int64 `json:"bytes"`
+	Objects int64 `json:"objects"`
+}
+
+// UserUsage is what the user stores with its quota
+type UserUsage struct {
+	models.Usage
+	Quota Quota `json:"quota"`
+}
+
+// UsageReport is what every user stores
+type UsageReport struct {
+	Total      models.Usage   `json:"total"`
+	TotalBytes int64          `json:"total_bytes_limit"`
+	Users      []models.Usage `json:"users"`
+}
+
+// Usage returns what the request's principal stores
+func (s *ImageService) Usage(ctx context.Context) (UserUsage, error) {
+	usage, err := s.usage.Get(ctx, viewer(ctx).Subject)
+	if err != nil {
+		return UserUsage{}, err
+	}
+
+	return UserUsage{Usage: usage, Quota: Quota{Bytes: s.quota.Bytes, Objects: s.quota.Objects}}, nil
+}
+
+func (s *ImageService) UsageReport(ctx context.Context) (UsageReport, error) {
+	total, err := s.usage.Total(ctx)
+	if err != nil {
+		return UsageReport{}, err
+	}
+
+	users, err := s.usage.List(ctx)
+	if err != nil {
+		return UsageReport{}, err
+	}
+	if users == nil {
+		users = []models.Usage{}
+	}
+
+	return UsageReport{Total: total, TotalBytes: s.quota.TotalBytes, Users: users}, nil
+}
+
+// allowance checks the quotas before anything is stored and limits the content to
+// the bytes left. Pending is stored by the request already, but isn't saved yet.
+// Usage is counted once content is saved, so concurrent uploads can go over a quota
+// by the size of what they store.
+func (s *ImageService) allowance(ctx context.Context, content io.Reader, pending models.Usage) (io.Reader, error) {
+	left, exceeded, err := s.remaining(ctx, pending)
+	if err != nil {
+		return nil, err
+	}
+
+	if exceeded == nil {
+		return content, nil
+	}
+	if left <= 0 {
+		return nil, exceeded
+	}
+
+	return &quotaReader{r: content, n: left, err: exceeded}, nil
+}
+
+// fits checks the quotas for content of the given size before it's sent,
+// the content is limited by allowance again once it's stored
+func (s *ImageService) fits(ctx context.Context, size int64) error {
+	left, exceeded, err := s.remaining(ctx, models.Usage{})
+	if err != nil {
+		return err
+	}
+
+	if exceeded != nil && (left <= 0 || size > left) {
+		return exceeded
+	}
+
+	return nil
+}
+
+// remaining returns the bytes left by the tightest quota and the error going over it fails with,
+// no error means nothing is limited
+func (s *ImageService) remaining(ctx context.Context, pending models.Usage) (left int64, exceeded error, err error) {
+	subject := viewer(ctx).Subject
+	if subject != "" && (s.quota.Bytes > 0 || s.quota.Objects > 0) {
+		usage, err := s.usage.Get(ctx, subject)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if s.quota.Objects > 0 && usage.Objects+pending.Objects >= s.quota.Objects {
+			return 0, ErrQuotaExceeded, nil
+		}
+		if s.quota.Bytes > 0 {
+			left, exceeded = s.quota.Bytes-usage.Bytes-pending.Bytes, ErrQuotaExceeded
+		}
+	}
+
+	if s.quota.TotalBytes > 0 {
+		total, err := s.usage.Total(ctx)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if free := s.quota.TotalBytes - total.Bytes - pending.Bytes; exceeded == nil || free < left {
+			left, exceeded = free, ErrStorageFull
+		}
+	}
+
+	return left, exceeded, nil
+}
+
+// quotaReader fails with err once more than n bytes are read
+type quotaReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	if q.n < 0 {
+		return 0, q.err
+	}
+
+	// one more byte than allowed tells the content is too large
+	if int64(len(p)) > q.n+1 {
+		p = p[:q.n+1]
+	}
+
+	n, err := q.r.Read(p)
+	if int64(n) <= q.n {
+		q.n -= int64(n)
+		return n, err
+	}
+
+	n = int(q.n)
+	q.n = -1
+	return n, q.err
+}
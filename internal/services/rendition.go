@@ -0,0 +1,260 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"testapp/internal/models"
+	"testapp/internal/repositories"
+	"testapp/pkg/imaging"
+)
+
+const RENDITION_QUEUE_SIZE = 256
+
+type renditionJob struct {
+	parent    models.Image
+	rendition models.Image
+	options   imaging.Options
+}
+
+type RenditionService struct {
+	rep        repositories.ImageRepository
+	images     *ImageService
+	renditions []imaging.Rendition
+	workers    int
+	jobs       chan renditionJob
+
+	// renditions in the queue or being generated, so they aren't queued twice
+	mu     sync.Mutex
+	queued map[uuid.UUID]bool
+}
+
+func NewRenditionService(rep repositories.ImageRepository, images *ImageService, renditions []imaging.Rendition, workers int) *RenditionService {
+	if workers <= 0 {
+		workers = imaging.DEFAULT_WORKERS
+	}
+
+	return &RenditionService{
+		rep:        rep,
+		images:     images,
+		renditions: renditions,
+		workers:    workers,
+		jobs:       make(chan renditionJob, RENDITION_QUEUE_SIZE),
+		queued:     make(map[uuid.UUID]bool),
+	}
+}
+
+// Enqueue records every configured rendition of the image as pending and
+// queues it for the workers. Uploads don't wait for a full queue, what isn't
+// queued stays pending until Run finds it.
+func (s *RenditionService) Enqueue(ctx context.Context, parent models.Image) error {
+	// the image is saved, its renditions are recorded even when the request is gone
+	ctx = context.WithoutCancel(ctx)
+
+	for _, r := range s.renditions {
+		options := r.Options().Normalize(parent.ContentType)
+		rendition := models.NewRendition(uuid.New(), parent.ID, r.Name, imaging.ContentType(options.Format))
+
+		rendition, err := s.rep.Create(ctx, rendition)
+		if err != nil {
+			return err
+		}
+
+		s.queue(renditionJob{parent: parent, rendition: rendition, options: options})
+	}
+
+	return nil
+}
+
+// queue hands the job to the workers unless the queue is full, it tells whether it did
+func (s *RenditionService) queue(job renditionJob) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.queued[job.rendition.ID] {
+		return true
+	}
+
+	select {
+	case s.jobs <- job:
+		s.queued[job.rendition.ID] = true
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *RenditionService) done(job renditionJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.queued, job.rendition.ID)
+}
+
+// requeue queues renditions left pending, the queue was full when they were recorded
+// or they were lost when the server stopped
+func (s *RenditionService) requeue(ctx context.Context) error {
+	pending, err := s.rep.Pending(ctx, RENDITION_QUEUE_SIZE)
+	if err != nil {
+		return err
+	}
+
+	for _, rendition := range pending {
+		// renditions that aren't configured anymore are given up, so they don't fill every batch
+		r, ok := s.rendition(rendition.Rendition)
+		if !ok {
+			s.fail(ctx, rendition)
+			continue
+		}
+
+		parent, err := s.rep.Get(ctx, *rendition.ParentID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			s.fail(ctx, rendition)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		options := r.Options().Normalize(parent.ContentType)
+		if !s.queue(renditionJob{parent: parent, rendition: rendition, options: options}) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (s *RenditionService) rendition(name string) (imaging.Rendition, bool) {
+	for _, r := range s.renditions {
+		if r.Name == name {
+			return r, true
+		}
+	}
+
+	return imaging.Rendition{}, false
+}
+
+// Get returns the rendition with the visibility of its image, renditions have none of their own
+func (s *RenditionService) Get(ctx context.Context, parentID uuid.UUID, name string) (models.Image, error) {
+	parent, err := s.images.Get(ctx, parentID)
+	if err != nil {
+		return models.Image{}, err
+	}
+
+	rendition, err := s.rep.GetRendition(ctx, parentID, name)
+	if err != nil {
+		return models.Image{}, err
+	}
+	rendition.Visibility = parent.Visibility
+
+	return rendition, nil
+}
+
+func (s *RenditionService) List(ctx context.Context, parentID uuid.UUID) ([]models.Image, error) {
+	if _, err := s.images.Get(ctx, parentID); err != nil {
+		return nil, err
+	}
+
+	return s.rep.Renditions(ctx, parentID)
+}
+
+// Run generates queued renditions with a pool of workers until ctx is done.
+// Renditions left pending are queued on start and every interval.
+func (s *RenditionService) Run(ctx context.Context, interval time.Duration) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-s.jobs:
+					s.generate(ctx, job)
+					s.done(job)
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.requeue(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to queue pending renditions: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *RenditionService) generate(ctx context.Context, job renditionJob) {
+	rendition := job.rendition
+
+	if err := s.render(ctx, job, &rendition); err != nil {
+		log.Printf("Failed to generate rendition %s of image %s: %v", rendition.Rendition, job.parent.ID, err)
+		s.fail(ctx, rendition)
+	}
+}
+
+func (s *RenditionService) fail(ctx context.Context, rendition models.Image) {
+	rendition.Status = models.STATUS_FAILED
+	if _, err := s.rep.Update(ctx, rendition); err != nil {
+		log.Printf("Failed to mark rendition %s of image %s as failed: %v", rendition.Rendition, *rendition.ParentID, err)
+	}
+}
+
+func (s *RenditionService) render(ctx context.Context, job renditionJob, rendition *models.Image) error {
+	// renditions too large for the image's aspect ratio fail without decoding it
+	if job.parent.Width > 0 && job.parent.Height > 0 {
+		if _, _, err := imaging.OutputSize(job.parent.Width, job.parent.Height, job.options); err != nil {
+			return err
+		}
+	}
+
+	img, err := s.images.decode(ctx, job.parent)
+	if err != nil {
+		return err
+	}
+
+	transformed, err := imaging.Transform(img, job.options)
+	if err != nil {
+		return err
+	}
+
+	var variant bytes.Buffer
+	if err := imaging.Encode(&variant, transformed, job.options.Format, job.options.Quality); err != nil {
+		return err
+	}
+
+	if err := s.images.write(ctx, rendition, &variant); err != nil {
+		return err
+	}
+	rendition.Status = models.STATUS_READY
+
+	updated, err := s.rep.Update(ctx, *rendition)
+	if err != nil {
+		s.images.store.Delete(ctx, rendition.StorageKey)
+		rendition.Digest, rendition.StorageKey, rendition.Size = "", "", 0
+		return err
+	}
+	s.images.settle(ctx, rendition.StorageKey, updated)
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"path"
+
+	"github.com/google/uuid"
+
+	"testapp/internal/models"
+	"testapp/pkg/imaging"
+	"testapp/pkg/storage"
+)
+
+const VARIANTS_PREFIX = "variants"
+
+type TransformService struct {
+	images *ImageService
+	cache  storage.BlobStore
+}
+
+func NewTransformService(images *ImageService, cache storage.BlobStore) *TransformService {
+	return &TransformService{images: images, cache: cache}
+}
+
+// Variant is an image transformed by some options
+type Variant struct {
+	io.ReadSeekCloser
+	ContentType string
+	// ETag is derived from the image's digest and the options, which always produce the same content
+	ETag string
+}
+
+// Open returns the image transformed by o, derived variants are cached by image ID and normalized options
+func (s *TransformService) Open(ctx context.Context, image models.Image, o imaging.Options) (Variant, error) {
+	if err := o.Check(image.ContentType); err != nil {
+		return Variant{}, err
+	}
+
+	o = o.Normalize(image.ContentType)
+	v := Variant{ContentType: imaging.ContentType(o.Format)}
+	if image.Digest != "" {
+		v.ETag = image.Digest + "-" + optionsHash(o)
+	}
+	key := variantKey(image, o)
+
+	cached, err := s.cache.Get(ctx, key)
+	if err == nil {
+		v.ReadSeekCloser, err = seekable(cached)
+		return v, err
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return Variant{}, err
+	}
+
+	variant, err := s.transform(ctx, image, o)
+	if err != nil {
+		return Variant{}, err
+	}
+
+	if _, err := s.cache.Put(ctx, key, bytes.NewReader(variant)); err != nil {
+		return Variant{}, err
+	}
+
+	v.ReadSeekCloser = nopSeekCloser{bytes.NewReader(variant)}
+	return v, nil
+}
+
+// Forget drops cached variants of the image
+func (s *TransformService) Forget(ctx context.Context, id uuid.UUID) error {
+	variants, err := s.cache.List(ctx, variantsPrefix(id))
+	if err != nil {
+		return err
+	}
+
+	for _, variant := range variants {
+		if err := s.cache.Delete(ctx, variant.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *TransformService) transform(ctx context.Context, image models.Image, o imaging.Options) ([]byte, error) {
+	img, err := s.images.decode(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+
+	transformed, err := imaging.Transform(img, o)
+	if err != nil {
+		return nil, err
+	}
+
+	var variant bytes.Buffer
+	if err := imaging.Encode(&variant, transformed, o.Format, o.Quality); err != nil {
+		return nil, err
+	}
+
+	return variant.Bytes(), nil
+}
+
+func variantsPrefix(id uuid.UUID) string {
+	return path.Join(VARIANTS_PREFIX, id.String()) + "/"
+}
+
+func variantKey(image models.Image, o imaging.Options) string {
+	return variantsPrefix(image.ID) + optionsHash(o) + "." + o.Format
+}
+
+func optionsHash(o imaging.Options) string {
+	sum := sha256.Sum256([]byte(o.Key()))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,308 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"testapp/internal/models"
+	"testapp/internal/repositories"
+	"testapp/pkg/storage"
+)
+
+const (
+	UPLOADS_PREFIX = "uploads"
+
+	UploadExpiration = 24 * time.Hour
+)
+
+var (
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	ErrUploadExpired  = errors.New("upload expired")
+)
+
+type UploadService struct {
+	rep    repositories.UploadRepository
+	store  storage.BlobStore
+	images *ImageService
+
+	// chunks of one upload are appended under the same lock
+	locks [64]sync.Mutex
+}
+
+func NewUploadService(rep repositories.UploadRepository, store storage.BlobStore, images *ImageService) *UploadService {
+	return &UploadService{rep: rep, store: store, images: images}
+}
+
+// MaxSize returns the size of the largest upload the store holds, 0 when there's no limit
+func (s *UploadService) MaxSize() int64 {
+	return s.images.MaxSize()
+}
+
+// Create starts an upload of length bytes, uploads going over a quota are refused
+// before anything is sent
+func (s *UploadService) Create(ctx context.Context, length int64, contentType, filename, metadata string) (models.Upload, error) {
+	if err := s.images.fits(ctx, length); err != nil {
+		return models.Upload{}, err
+	}
+
+	upload := models.NewUpload(uuid.New(), length, contentType, filename, metadata, time.Now().Add(UploadExpiration))
+	upload.OwnerID = viewer(ctx).Subject
+
+	if err := s.rep.Create(ctx, upload); err != nil {
+		return models.Upload{}, err
+	}
+
+	return upload, nil
+}
+
+func (s *UploadService) Get(ctx context.Context, id uuid.UUID) (models.Upload, error) {
+	upload, err := s.owned(ctx, id)
+	if err != nil {
+		return models.Upload{}, err
+	}
+
+	if time.Now().After(upload.ExpiresAt) {
+		return models.Upload{}, ErrUploadExpired
+	}
+
+	return upload, nil
+}
+
+// Append stores the chunk starting at offset. Whatever arrived before a dropped
+// connection is kept, so the client can resume from the returned offset.
+// Once the last byte is received the chunks are assembled into an image.
+func (s *UploadService) Append(ctx context.Context, id uuid.UUID, offset int64, content io.Reader) (_ models.Upload, err error) {
+	ctx, span := tracer.Start(ctx, "UploadService.Append", trace.WithAttributes(attribute.String("upload.id", id.String()), attribute.Int64("upload.offset", offset)))
+	defer func() { end(span, err) }()
+
+	lock := s.lock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload, err := s.Get(ctx, id)
+	if err != nil {
+		return models.Upload{}, err
+	}
+
+	if upload.Offset != offset {
+		return upload, ErrOffsetMismatch
+	}
+
+	received := &partialReader{r: io.LimitReader(content, upload.Length-upload.Offset)}
+
+	if !upload.Completed() {
+		key := chunkKey(upload.ID, offset)
+
+		info, err := s.store.Put(ctx, key, received)
+		if err != nil {
+			return upload, err
+		}
+
+		if info.Size == 0 {
+			s.store.Delete(ctx, key)
+			return upload, received.err
+		}
+		uploadBytes.WithLabelValues(UPLOAD_KIND_RESUMABLE).Add(float64(info.Size))
+
+		upload.Offset += info.Size
+		if err := s.rep.Update(ctx, upload); err != nil {
+			s.store.Delete(ctx, key)
+			return models.Upload{}, err
+		}
+	}
+
+	if upload.Completed() && upload.ImageID == nil {
+		if upload, err = s.complete(ctx, upload); err != nil {
+			return upload, err
+		}
+	}
+
+	return upload, received.err
+}
+
+func (s *UploadService) Terminate(ctx context.Context, id uuid.UUID) error {
+	lock := s.lock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, err := s.owned(ctx, id); err != nil {
+		return err
+	}
+
+	return s.remove(ctx, id)
+}
+
+// owned returns the upload when the viewer created it. Uploads of others aren't found,
+// uploads nobody created, stored before uploads had owners, aren't found by anyone.
+func (s *UploadService) owned(ctx context.Context, id uuid.UUID) (models.Upload, error) {
+	upload, err := s.rep.Get(ctx, id)
+	if err != nil {
+		return models.Upload{}, err
+	}
+
+	if upload.OwnerID == "" || upload.OwnerID != viewer(ctx).Subject {
+		return models.Upload{}, repositories.ErrNotFound
+	}
+
+	return upload, nil
+}
+
+func (s *UploadService) PurgeExpired(ctx context.Context) error {
+	uploads, err := s.rep.Expired(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, upload := range uploads {
+		if err := s.remove(ctx, upload.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run purges expired uploads every interval until ctx is done
+func (s *UploadService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.PurgeExpired(ctx); err != nil {
+				log.Printf("Failed to purge expired uploads: %v", err)
+			}
+		}
+	}
+}
+
+func (s *UploadService) complete(ctx context.Context, upload models.Upload) (models.Upload, error) {
+	chunks, err := s.store.List(ctx, chunksPrefix(upload.ID))
+	if err != nil {
+		return upload, err
+	}
+
+	content := &chunksReader{ctx: ctx, store: s.store, chunks: chunks}
+	defer content.Close()
+
+	image, err := s.images.SaveFileToDB(ctx, upload.Filename, upload.ContentType, content)
+	if err != nil {
+		return upload, err
+	}
+
+	upload.ImageID = &image.ID
+	if err := s.rep.Update(ctx, upload); err != nil {
+		return upload, err
+	}
+
+	for _, chunk := range chunks {
+		s.store.Delete(ctx, chunk.Key)
+	}
+
+	return upload, nil
+}
+
+func (s *UploadService) remove(ctx context.Context, id uuid.UUID) error {
+	chunks, err := s.store.List(ctx, chunksPrefix(id))
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks {
+		if err := s.store.Delete(ctx, chunk.Key); err != nil {
+			return err
+		}
+	}
+
+	return s.rep.Delete(ctx, id)
+}
+
+func (s *UploadService) lock(id uuid.UUID) *sync.Mutex {
+	hash := fnv.New32a()
+	hash.Write(id[:])
+
+	return &s.locks[hash.Sum32()%uint32(len(s.locks))]
+}
+
+func chunksPrefix(id uuid.UUID) string {
+	return path.Join(UPLOADS_PREFIX, id.String()) + "/"
+}
+
+// offsets are zero padded, so listing the chunks by key keeps them in order
+func chunkKey(id uuid.UUID, offset int64) string {
+	return chunksPrefix(id) + fmt.Sprintf("%020d", offset)
+}
+
+// partialReader ends the stream on a read error instead of failing it
+type partialReader struct {
+	r   io.Reader
+	err error
+}
+
+func (p *partialReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if err != nil && err != io.EOF {
+		p.err = err
+		return n, io.EOF
+	}
+
+	return n, err
+}
+
+// chunksReader reads the stored chunks one after another, opening each only when needed
+type chunksReader struct {
+	ctx     context.Context
+	store   storage.BlobStore
+	chunks  []storage.BlobInfo
+	current io.ReadCloser
+}
+
+func (c *chunksReader) Read(b []byte) (int, error) {
+	for {
+		if c.current == nil {
+			if len(c.chunks) == 0 {
+				return 0, io.EOF
+			}
+
+			chunk, err := c.store.Get(c.ctx, c.chunks[0].Key)
+			if err != nil {
+				return 0, err
+			}
+			c.current, c.chunks = chunk, c.chunks[1:]
+		}
+
+		n, err := c.current.Read(b)
+		if err == io.EOF {
+			c.current.Close()
+			c.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+
+		return n, err
+	}
+}
+
+func (c *chunksReader) Close() error {
+	if c.current == nil {
+		return nil
+	}
+
+	return c.current.Close()
+}
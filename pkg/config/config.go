@@ -4,12 +4,20 @@ import (
 	"github.com/spf13/viper"
 
 	"testapp/pkg/http"
+	"testapp/pkg/imaging"
+	"testapp/pkg/metrics"
 	"testapp/pkg/pgsql"
+	"testapp/pkg/storage"
+	"testapp/pkg/tracing"
 )
 
 type Config struct {
 	HTTP http.Config
 	PgSQL pgsql.Config
+	Storage storage.Config
+	Imaging imaging.Config
+	Metrics metrics.Config
+	Tracing tracing.Config
 }
 
 func LoadConfig(filename, ext, path string) (Config, error) {
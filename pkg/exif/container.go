@@ -0,0 +1,142 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	ErrNotFound = errors.New("no exif data")
+
+	jpegExifHeader = []byte("Exif\x00\x00")
+	pngSignature   = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// MAX_SIZE limits how much EXIF data is read, APP1 can't be larger anyway
+const MAX_SIZE = 64 << 10
+
+// Extract finds raw EXIF data in JPEG, PNG or WebP files
+func Extract(r io.ReadSeeker) ([]byte, error) {
+	head := make([]byte, 12)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, ErrNotFound
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte{0xff, 0xd8}):
+		return extractJPEG(r)
+	case bytes.HasPrefix(head, pngSignature[:8]):
+		return extractPNG(r)
+	case bytes.HasPrefix(head, []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP")):
+		return extractWebP(r)
+	}
+
+	return nil, ErrNotFound
+}
+
+func extractJPEG(r io.ReadSeeker) ([]byte, error) {
+	if _, err := r.Seek(2, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, ErrNotFound
+		}
+
+		if header[0] != 0xff {
+			return nil, ErrInvalid
+		}
+
+		marker := header[1]
+		// image data starts after SOS, metadata is always before it
+		if marker == 0xda || marker == 0xd9 {
+			return nil, ErrNotFound
+		}
+
+		length := int64(binary.BigEndian.Uint16(header[2:])) - 2
+		if length < 0 {
+			return nil, ErrInvalid
+		}
+
+		if marker == 0xe1 {
+			segment, err := readSegment(r, length)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.HasPrefix(segment, jpegExifHeader) {
+				return segment[len(jpegExifHeader):], nil
+			}
+			continue
+		}
+
+		if _, err := r.Seek(length, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func extractPNG(r io.ReadSeeker) ([]byte, error) {
+	if _, err := r.Seek(int64(len(pngSignature)), io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, ErrNotFound
+		}
+
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		switch string(header[4:]) {
+		case "eXIf":
+			return readSegment(r, length)
+		case "IDAT", "IEND":
+			return nil, ErrNotFound
+		}
+
+		// skip the data and crc
+		if _, err := r.Seek(length+4, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func extractWebP(r io.ReadSeeker) ([]byte, error) {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, ErrNotFound
+		}
+
+		length := int64(binary.LittleEndian.Uint32(header[4:]))
+		if string(header[:4]) == "EXIF" {
+			data, err := readSegment(r, length)
+			if err != nil {
+				return nil, err
+			}
+			return bytes.TrimPrefix(data, jpegExifHeader), nil
+		}
+
+		// chunks are padded to an even size
+		if _, err := r.Seek(length+length%2, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func readSegment(r io.Reader, length int64) ([]byte, error) {
+	if length > MAX_SIZE {
+		return nil, ErrInvalid
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, ErrInvalid
+	}
+
+	return data, nil
+}
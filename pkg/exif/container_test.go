@@ -0,0 +1,77 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func webpChunk(typ string, data []byte) []byte {
+	chunk := binary.LittleEndian.AppendUint32([]byte(typ), uint32(len(data)))
+	chunk = append(chunk, data...)
+	// chunks are padded to an even size
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func webpFile(chunks ...[]byte) []byte {
+	var body []byte
+	for _, chunk := range chunks {
+		body = append(body, chunk...)
+	}
+
+	file := binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body)+4))
+	file = append(file, "WEBP"...)
+	return append(file, body...)
+}
+
+func TestExtract(t *testing.T) {
+	raw := cameraTIFF(binary.BigEndian)
+	exifSegment := jpegSegment(0xe1, append([]byte("Exif\x00\x00"), raw...))
+	xmpSegment := jpegSegment(0xe1, append(append([]byte{}, jpegXMPHeader...), "<x/>"...))
+	app0 := jpegSegment(0xe0, []byte("JFIF\x00\x01\x02"))
+
+	tests := []struct {
+		name string
+		file []byte
+		err  error
+	}{
+		{name: "jpeg", file: jpegFile(app0, exifSegment)},
+		{name: "jpeg with xmp first", file: jpegFile(xmpSegment, exifSegment)},
+		{name: "jpeg without exif", file: jpegFile(app0, xmpSegment), err: ErrNotFound},
+		{name: "jpeg with exif after its scan", file: append(jpegFile(app0), exifSegment...), err: ErrNotFound},
+		{name: "jpeg with garbage between segments", file: append([]byte{0xff, 0xd8, 0x12, 0x34}, exifSegment...), err: ErrInvalid},
+		{name: "truncated jpeg segment", file: append([]byte{0xff, 0xd8}, exifSegment[:40]...), err: ErrInvalid},
+
+		{name: "png", file: pngFile(pngChunk("tEXt", []byte("a\x00b")), pngChunk("eXIf", raw))},
+		{name: "png without exif", file: pngFile(), err: ErrNotFound},
+		{name: "png with exif after its data", file: append(pngFile(), pngChunk("eXIf", raw)...), err: ErrNotFound},
+		{name: "truncated png chunk", file: pngFile(pngChunk("eXIf", raw))[:60], err: ErrInvalid},
+
+		{name: "webp", file: webpFile(webpChunk("VP8X", make([]byte, 10)), webpChunk("ICCP", []byte{1, 2, 3}), webpChunk("EXIF", raw))},
+		{name: "webp with exif header", file: webpFile(webpChunk("EXIF", append([]byte("Exif\x00\x00"), raw...)))},
+		{name: "webp without exif", file: webpFile(webpChunk("VP8L", []byte{1, 2, 3})), err: ErrNotFound},
+		{name: "webp exif larger than allowed", file: webpFile(webpChunk("EXIF", make([]byte, MAX_SIZE+1))), err: ErrInvalid},
+
+		{name: "other files", file: []byte("GIF89a and some more bytes"), err: ErrNotFound},
+		{name: "short files", file: []byte{0xff, 0xd8}, err: ErrNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			extracted, err := Extract(bytes.NewReader(test.file))
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, raw, extracted)
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package exif
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	TAG_MAKE              = 0x010f
+	TAG_MODEL             = 0x0110
+	TAG_ORIENTATION       = 0x0112
+	TAG_DATETIME          = 0x0132
+	TAG_EXIF_IFD          = 0x8769
+	TAG_GPS_IFD           = 0x8825
+	TAG_DATETIME_ORIGINAL = 0x9003
+
+	TAG_GPS_LATITUDE_REF  = 0x0001
+	TAG_GPS_LATITUDE      = 0x0002
+	TAG_GPS_LONGITUDE_REF = 0x0003
+	TAG_GPS_LONGITUDE     = 0x0004
+	TAG_GPS_ALTITUDE_REF  = 0x0005
+	TAG_GPS_ALTITUDE      = 0x0006
+
+	DATETIME_LAYOUT = "2006:01:02 15:04:05"
+)
+
+var ErrInvalid = errors.New("invalid exif data")
+
+type Data struct {
+	Make        string     `json:"make,omitempty"`
+	Model       string     `json:"model,omitempty"`
+	Orientation int        `json:"orientation,omitempty"`
+	CapturedAt  *time.Time `json:"captured_at,omitempty"`
+	Latitude    *float64   `json:"latitude,omitempty"`
+	Longitude   *float64   `json:"longitude,omitempty"`
+	Altitude    *float64   `json:"altitude,omitempty"`
+}
+
+type entry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	// offset of the value inside the tiff data
+	offset uint32
+}
+
+// tiff is the TIFF structure EXIF is stored in
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func newTIFF(data []byte) (*tiff, error) {
+	if len(data) < 8 {
+		return nil, ErrInvalid
+	}
+
+	t := &tiff{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, ErrInvalid
+	}
+
+	if t.order.Uint16(data[2:4]) != 42 {
+		return nil, ErrInvalid
+	}
+
+	return t, nil
+}
+
+// Parse reads camera, orientation, capture time and GPS position from raw EXIF (TIFF) data
+func Parse(data []byte) (Data, error) {
+	t, err := newTIFF(data)
+	if err != nil {
+		return Data{}, err
+	}
+
+	ifd0, err := t.ifd(t.order.Uint32(data[4:8]))
+	if err != nil {
+		return Data{}, err
+	}
+
+	var d Data
+	d.Make = t.string(ifd0[TAG_MAKE])
+	d.Model = t.string(ifd0[TAG_MODEL])
+	d.Orientation = int(t.uint(ifd0[TAG_ORIENTATION]))
+	d.CapturedAt = parseTime(t.string(ifd0[TAG_DATETIME]))
+
+	if e, ok := ifd0[TAG_EXIF_IFD]; ok {
+		if exifIFD, err := t.ifd(t.uint(e)); err == nil {
+			if captured := parseTime(t.string(exifIFD[TAG_DATETIME_ORIGINAL])); captured != nil {
+				d.CapturedAt = captured
+			}
+		}
+	}
+
+	if e, ok := ifd0[TAG_GPS_IFD]; ok {
+		if gps, err := t.ifd(t.uint(e)); err == nil {
+			d.Latitude = t.coordinate(gps[TAG_GPS_LATITUDE], t.string(gps[TAG_GPS_LATITUDE_REF]), "S")
+			d.Longitude = t.coordinate(gps[TAG_GPS_LONGITUDE], t.string(gps[TAG_GPS_LONGITUDE_REF]), "W")
+			if altitude := t.rationals(gps[TAG_GPS_ALTITUDE]); len(altitude) == 1 {
+				if t.uint(gps[TAG_GPS_ALTITUDE_REF]) == 1 {
+					altitude[0] = -altitude[0]
+				}
+				d.Altitude = &altitude[0]
+			}
+		}
+	}
+
+	return d, nil
+}
+
+func (t *tiff) ifd(offset uint32) (map[uint16]entry, error) {
+	if int64(offset)+2 > int64(len(t.data)) {
+		return nil, ErrInvalid
+	}
+
+	count := int(t.order.Uint16(t.data[offset:]))
+	start := int64(offset) + 2
+	if start+int64(count)*12 > int64(len(t.data)) {
+		return nil, ErrInvalid
+	}
+
+	entries := make(map[uint16]entry, count)
+	for i := 0; i < count; i++ {
+		raw := t.data[start+int64(i)*12:]
+		e := entry{
+			tag:   t.order.Uint16(raw[0:2]),
+			typ:   t.order.Uint16(raw[2:4]),
+			count: t.order.Uint32(raw[4:8]),
+		}
+
+		// values of up to 4 bytes are stored in the entry itself
+		if size := int64(typeSize(e.typ)) * int64(e.count); size <= 4 {
+			e.offset = uint32(start) + uint32(i)*12 + 8
+		} else {
+			e.offset = t.order.Uint32(raw[8:12])
+		}
+		entries[e.tag] = e
+	}
+
+	return entries, nil
+}
+
+func (t *tiff) value(e entry) []byte {
+	size := int64(typeSize(e.typ)) * int64(e.count)
+	if size == 0 || int64(e.offset)+size > int64(len(t.data)) {
+		return nil
+	}
+
+	return t.data[e.offset : int64(e.offset)+size]
+}
+
+func (t *tiff) string(e entry) string {
+	if e.typ != 2 {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimRight(string(t.value(e)), "\x00"))
+}
+
+func (t *tiff) uint(e entry) uint32 {
+	value := t.value(e)
+
+	switch {
+	case e.typ == 1 && len(value) >= 1:
+		return uint32(value[0])
+	case e.typ == 3 && len(value) >= 2:
+		return uint32(t.order.Uint16(value))
+	case e.typ == 4 && len(value) >= 4:
+		return t.order.Uint32(value)
+	}
+
+	return 0
+}
+
+func (t *tiff) rationals(e entry) []float64 {
+	if e.typ != 5 {
+		return nil
+	}
+
+	value := t.value(e)
+	rationals := make([]float64, 0, e.count)
+	for i := 0; i+8 <= len(value); i += 8 {
+		num, denom := t.order.Uint32(value[i:]), t.order.Uint32(value[i+4:])
+		if denom == 0 {
+			return nil
+		}
+		rationals = append(rationals, float64(num)/float64(denom))
+	}
+
+	return rationals
+}
+
+// coordinate converts degrees, minutes and seconds to signed decimal degrees
+func (t *tiff) coordinate(e entry, ref, negativeRef string) *float64 {
+	dms := t.rationals(e)
+	if len(dms) != 3 {
+		return nil
+	}
+
+	degrees := dms[0] + dms[1]/60 + dms[2]/3600
+	if ref == negativeRef {
+		degrees = -degrees
+	}
+	degrees = math.Round(degrees*1e7) / 1e7
+
+	return &degrees
+}
+
+func typeSize(typ uint16) int {
+	switch typ {
+	case 1, 2, 6, 7:
+		return 1
+	case 3, 8:
+		return 2
+	case 4, 9, 11:
+		return 4
+	case 5, 10, 12:
+		return 8
+	}
+
+	return 0
+}
+
+func parseTime(value string) *time.Time {
+	parsed, err := time.Parse(DATETIME_LAYOUT, value)
+	if err != nil {
+		return nil
+	}
+
+	return &parsed
+}
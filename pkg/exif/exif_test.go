@@ -0,0 +1,203 @@
+package exif
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// byteOrder reads and appends, both TIFF byte orders do
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// field is an IFD entry, fields with an ifd point at the IFD with that index
+type field struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+	ifd   int
+}
+
+func ascii(tag uint16, value string) field {
+	return field{tag: tag, typ: 2, count: uint32(len(value) + 1), value: append([]byte(value), 0)}
+}
+
+func short(order byteOrder, tag uint16, value uint16) field {
+	return field{tag: tag, typ: 3, count: 1, value: order.AppendUint16(nil, value)}
+}
+
+func rationals(order byteOrder, tag uint16, values ...uint32) field {
+	f := field{tag: tag, typ: 5, count: uint32(len(values) / 2)}
+	for _, value := range values {
+		f.value = order.AppendUint32(f.value, value)
+	}
+	return f
+}
+
+func pointer(tag uint16, ifd int) field {
+	return field{tag: tag, typ: 4, count: 1, ifd: ifd}
+}
+
+// tiffData lays out the IFDs one after another from offset 8, values that don't fit
+// in their entry follow them
+func tiffData(order byteOrder, ifds ...[]field) []byte {
+	offsets := make([]uint32, len(ifds))
+	end := uint32(8)
+	for i, ifd := range ifds {
+		offsets[i] = end
+		end += 2 + uint32(len(ifd))*12 + 4
+	}
+
+	data := []byte("II")
+	if order == binary.BigEndian {
+		data = []byte("MM")
+	}
+	data = order.AppendUint16(data, 42)
+	data = order.AppendUint32(data, offsets[0])
+
+	var values []byte
+	for _, ifd := range ifds {
+		data = order.AppendUint16(data, uint16(len(ifd)))
+		for _, f := range ifd {
+			data = order.AppendUint16(data, f.tag)
+			data = order.AppendUint16(data, f.typ)
+			data = order.AppendUint32(data, f.count)
+
+			switch {
+			case f.ifd > 0:
+				data = order.AppendUint32(data, offsets[f.ifd])
+			case len(f.value) <= 4:
+				data = append(data, f.value...)
+				data = append(data, make([]byte, 4-len(f.value))...)
+			default:
+				data = order.AppendUint32(data, end+uint32(len(values)))
+				values = append(values, f.value...)
+			}
+		}
+		data = order.AppendUint32(data, 0)
+	}
+
+	return append(data, values...)
+}
+
+// cameraTIFF has every field Parse reads, taken south west of Greenwich below sea level
+func cameraTIFF(order byteOrder) []byte {
+	return tiffData(order,
+		[]field{
+			ascii(TAG_MAKE, "Camera Inc."),
+			ascii(TAG_MODEL, "Model 1"),
+			short(order, TAG_ORIENTATION, 6),
+			ascii(TAG_DATETIME, "2024:05:06 07:08:09"),
+			pointer(TAG_EXIF_IFD, 1),
+			pointer(TAG_GPS_IFD, 2),
+		},
+		[]field{
+			ascii(TAG_DATETIME_ORIGINAL, "2024:05:06 07:00:00"),
+		},
+		[]field{
+			ascii(TAG_GPS_LATITUDE_REF, "S"),
+			rationals(order, TAG_GPS_LATITUDE, 33, 1, 51, 1, 54, 1),
+			ascii(TAG_GPS_LONGITUDE_REF, "W"),
+			rationals(order, TAG_GPS_LONGITUDE, 70, 1, 3, 1, 3600, 100),
+			{tag: TAG_GPS_ALTITUDE_REF, typ: 1, count: 1, value: []byte{1}},
+			rationals(order, TAG_GPS_ALTITUDE, 125, 10),
+		},
+	)
+}
+
+func TestParse(t *testing.T) {
+	captured := time.Date(2024, 5, 6, 7, 0, 0, 0, time.UTC)
+	latitude, longitude, altitude := -33.865, -70.06, -12.5
+
+	for name, order := range map[string]byteOrder{"little endian": binary.LittleEndian, "big endian": binary.BigEndian} {
+		t.Run(name, func(t *testing.T) {
+			data, err := Parse(cameraTIFF(order))
+			require.NoError(t, err)
+
+			assert.Equal(t, Data{
+				Make:        "Camera Inc.",
+				Model:       "Model 1",
+				Orientation: 6,
+				CapturedAt:  &captured,
+				Latitude:    &latitude,
+				Longitude:   &longitude,
+				Altitude:    &altitude,
+			}, data)
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	le := binary.LittleEndian
+	valid := cameraTIFF(le)
+
+	wrongMagic := append([]byte{}, valid...)
+	le.PutUint16(wrongMagic[2:], 43)
+
+	farIFD := append([]byte{}, valid...)
+	le.PutUint32(farIFD[4:], uint32(len(valid)))
+
+	tests := map[string][]byte{
+		"empty":              nil,
+		"short header":       valid[:6],
+		"unknown byte order": append([]byte("XX"), valid[2:]...),
+		"wrong magic":        wrongMagic,
+		"ifd out of data":    farIFD,
+		// the first IFD claims six entries, the data ends in the third
+		"truncated ifd": valid[:8+2+12*2+6],
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse(data)
+			assert.ErrorIs(t, err, ErrInvalid)
+		})
+	}
+}
+
+func TestParsePartial(t *testing.T) {
+	le := binary.LittleEndian
+
+	t.Run("truncated gps ifd", func(t *testing.T) {
+		data := tiffData(le, []field{ascii(TAG_MAKE, "Cam"), pointer(TAG_GPS_IFD, 1)}, []field{
+			ascii(TAG_GPS_LATITUDE_REF, "N"),
+			rationals(le, TAG_GPS_LATITUDE, 1, 1, 2, 1, 3, 1),
+		})
+		// the GPS IFD starts at 38 and holds two entries
+		parsed, err := Parse(data[:38+2+12])
+		require.NoError(t, err, "A broken GPS IFD fails the rest")
+		assert.Equal(t, "Cam", parsed.Make)
+		assert.Nil(t, parsed.Latitude)
+	})
+
+	t.Run("value out of data", func(t *testing.T) {
+		data := tiffData(le, []field{ascii(TAG_MAKE, "A camera"), short(le, TAG_ORIENTATION, 3)})
+		parsed, err := Parse(data[:len(data)-1])
+		require.NoError(t, err)
+		assert.Empty(t, parsed.Make)
+		assert.Equal(t, 3, parsed.Orientation)
+	})
+
+	t.Run("zero denominator", func(t *testing.T) {
+		data := tiffData(le, []field{pointer(TAG_GPS_IFD, 1)}, []field{
+			rationals(le, TAG_GPS_LATITUDE, 1, 1, 2, 0, 3, 1),
+			rationals(le, TAG_GPS_ALTITUDE, 5, 0),
+		})
+		parsed, err := Parse(data)
+		require.NoError(t, err)
+		assert.Nil(t, parsed.Latitude)
+		assert.Nil(t, parsed.Altitude)
+	})
+
+	t.Run("invalid time", func(t *testing.T) {
+		parsed, err := Parse(tiffData(le, []field{ascii(TAG_DATETIME, "yesterday")}))
+		require.NoError(t, err)
+		assert.Nil(t, parsed.CapturedAt)
+	})
+}
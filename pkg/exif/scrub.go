@@ -0,0 +1,393 @@
+package exif
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// metadata policies
+const (
+	KEEP      = "keep"
+	STRIP_GPS = "strip_gps"
+	STRIP_ALL = "strip_all"
+
+	DEFAULT_POLICY = STRIP_GPS
+)
+
+var (
+	ErrInvalidPolicy = errors.New("invalid metadata policy")
+	// ErrMalformed is returned for files whose structure can't be followed, they are
+	// rejected rather than stored with metadata the policy removes
+	ErrMalformed = errors.New("malformed image, its metadata can't be removed")
+
+	// XMP packets can repeat the GPS position, so they are dropped with it
+	jpegXMPHeader = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	pngXMPKeyword = []byte("XML:com.adobe.xmp\x00")
+)
+
+// VP8X flags telling which metadata chunks a WebP file has
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+func ValidPolicy(policy string) bool {
+	return policy == KEEP || policy == STRIP_GPS || policy == STRIP_ALL
+}
+
+// Scrub copies JPEG, PNG and WebP files from src to dst without the metadata the policy
+// removes: STRIP_GPS clears the GPS position, STRIP_ALL drops EXIF, XMP and text comments.
+// Other files are copied unchanged. Image data is streamed, only metadata is held in memory.
+// Files that can't be followed up to their image data fail with ErrMalformed.
+func Scrub(dst io.Writer, src io.Reader, policy string) error {
+	if !ValidPolicy(policy) {
+		return fmt.Errorf("%w: %q", ErrInvalidPolicy, policy)
+	}
+
+	buffered := bufio.NewReader(src)
+	if policy == KEEP {
+		_, err := io.Copy(dst, buffered)
+		return err
+	}
+
+	head, err := buffered.Peek(12)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte{0xff, 0xd8}):
+		return scrubJPEG(dst, buffered, policy)
+	case bytes.HasPrefix(head, pngSignature):
+		return scrubPNG(dst, buffered, policy)
+	case len(head) == 12 && bytes.HasPrefix(head, []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP")):
+		return scrubWebP(dst, buffered, policy)
+	}
+
+	_, err = io.Copy(dst, buffered)
+	return err
+}
+
+// ScrubGPS clears the GPS IFD of raw EXIF (TIFF) data in place, so its size doesn't change
+func ScrubGPS(data []byte) error {
+	t, err := newTIFF(data)
+	if err != nil {
+		return err
+	}
+
+	ifd0, err := t.ifd(t.order.Uint32(data[4:8]))
+	if err != nil {
+		return err
+	}
+
+	e, ok := ifd0[TAG_GPS_IFD]
+	if !ok {
+		return nil
+	}
+
+	offset := t.uint(e)
+	gps, err := t.ifd(offset)
+	if err != nil {
+		return err
+	}
+
+	// values that don't fit in their entries are stored elsewhere
+	for _, entry := range gps {
+		if int64(typeSize(entry.typ))*int64(entry.count) > 4 {
+			clear(t.value(entry))
+		}
+	}
+
+	// an empty IFD: zero entries followed by a zero next IFD offset
+	end := min(int64(offset)+2+int64(len(gps))*12+4, int64(len(data)))
+	clear(data[offset:end])
+
+	return nil
+}
+
+func scrubJPEG(dst io.Writer, src *bufio.Reader, policy string) error {
+	if _, err := io.CopyN(dst, src, 2); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(src, header[:2]); err != nil {
+			return truncated(err)
+		}
+		if header[0] != 0xff {
+			return ErrMalformed
+		}
+
+		// markers can be preceded by any number of 0xff fill bytes
+		for header[1] == 0xff {
+			b, err := src.ReadByte()
+			if err != nil {
+				return truncated(err)
+			}
+			header[1] = b
+		}
+
+		marker := header[1]
+		switch {
+		// the entropy coded data after SOS holds no metadata
+		case marker == 0xda || marker == 0xd9:
+			if _, err := dst.Write(header[:2]); err != nil {
+				return err
+			}
+			_, err := io.Copy(dst, src)
+			return err
+		// markers standing alone, without a length
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd7:
+			if _, err := dst.Write(header[:2]); err != nil {
+				return err
+			}
+			continue
+		case marker == 0x00:
+			return ErrMalformed
+		}
+
+		if _, err := io.ReadFull(src, header[2:]); err != nil {
+			return truncated(err)
+		}
+
+		length := int64(binary.BigEndian.Uint16(header[2:])) - 2
+		if length < 0 {
+			return ErrMalformed
+		}
+
+		switch {
+		case marker == 0xe1:
+			segment := make([]byte, length)
+			if _, err := io.ReadFull(src, segment); err != nil {
+				return truncated(err)
+			}
+
+			keep := policy != STRIP_ALL && !bytes.HasPrefix(segment, jpegXMPHeader)
+			if keep && bytes.HasPrefix(segment, jpegExifHeader) {
+				// unreadable EXIF is dropped rather than kept with the position in it
+				keep = ScrubGPS(segment[len(jpegExifHeader):]) == nil
+			}
+			if !keep {
+				continue
+			}
+
+			if _, err := dst.Write(header); err != nil {
+				return err
+			}
+			if _, err := dst.Write(segment); err != nil {
+				return err
+			}
+		// IPTC and comments
+		case policy == STRIP_ALL && (marker == 0xed || marker == 0xfe):
+			if _, err := io.CopyN(io.Discard, src, length); err != nil {
+				return truncated(err)
+			}
+		default:
+			if _, err := dst.Write(header); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(dst, src, length); err != nil {
+				return truncated(err)
+			}
+		}
+	}
+}
+
+func scrubPNG(dst io.Writer, src *bufio.Reader, policy string) error {
+	if _, err := io.CopyN(dst, src, int64(len(pngSignature))); err != nil {
+		return err
+	}
+
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(src, header); err != nil {
+			return truncated(err)
+		}
+
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		typ := string(header[4:])
+
+		switch {
+		// whatever follows the end isn't part of the image, so it isn't kept
+		case typ == "IEND":
+			if _, err := dst.Write(header); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(dst, src, length+4); err != nil {
+				return truncated(err)
+			}
+			return nil
+		case typ == "eXIf" && policy == STRIP_GPS && length+4 <= MAX_SIZE:
+			data := make([]byte, length+4)
+			if _, err := io.ReadFull(src, data); err != nil {
+				return truncated(err)
+			}
+			data = data[:length]
+			if ScrubGPS(data) != nil {
+				continue
+			}
+
+			crc := crc32.NewIEEE()
+			crc.Write(header[4:])
+			crc.Write(data)
+
+			if _, err := dst.Write(header); err != nil {
+				return err
+			}
+			if _, err := dst.Write(data); err != nil {
+				return err
+			}
+			if err := binary.Write(dst, binary.BigEndian, crc.Sum32()); err != nil {
+				return err
+			}
+		// EXIF too large to be scrubbed is dropped as well
+		case typ == "eXIf", policy == STRIP_ALL && (typ == "tEXt" || typ == "zTXt" || typ == "iTXt" || typ == "tIME"):
+			if _, err := io.CopyN(io.Discard, src, length+4); err != nil {
+				return truncated(err)
+			}
+		case typ == "iTXt":
+			keyword, _ := src.Peek(int(min(length, int64(len(pngXMPKeyword)))))
+			if bytes.Equal(keyword, pngXMPKeyword) {
+				if _, err := io.CopyN(io.Discard, src, length+4); err != nil {
+					return truncated(err)
+				}
+				continue
+			}
+			fallthrough
+		default:
+			if _, err := dst.Write(header); err != nil {
+				return err
+			}
+			// the data and its crc
+			if _, err := io.CopyN(dst, src, length+4); err != nil {
+				return truncated(err)
+			}
+		}
+	}
+}
+
+// truncated reports files ending before their structure does as malformed,
+// other errors are the reader's own
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrMalformed
+	}
+
+	return err
+}
+
+// scrubWebP writes the chunks it keeps to a temporary file, metadata chunks follow the
+// image data and the RIFF header in front of it has to carry the scrubbed size.
+// Only the EXIF chunk is held in memory, EXIF larger than MAX_SIZE is dropped.
+func scrubWebP(dst io.Writer, src io.Reader, policy string) error {
+	tmp, err := os.CreateTemp("", "scrub-*.webp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return truncated(err)
+	}
+	if _, err := tmp.Write(header); err != nil {
+		return err
+	}
+
+	var (
+		size    = int64(len(header))
+		flags   = int64(-1)
+		dropped = byte(webpFlagXMP)
+	)
+
+	for {
+		if _, err := io.ReadFull(src, header[:8]); err == io.EOF {
+			break
+		} else if err != nil {
+			return truncated(err)
+		}
+
+		typ := string(header[:4])
+		length := int64(binary.LittleEndian.Uint32(header[4:8]))
+
+		var chunk io.Reader = io.LimitReader(src, length)
+		switch {
+		case typ == "EXIF" && policy == STRIP_GPS && length <= MAX_SIZE:
+			data := make([]byte, length)
+			if _, err := io.ReadFull(src, data); err != nil {
+				return truncated(err)
+			}
+			if ScrubGPS(bytes.TrimPrefix(data, jpegExifHeader)) != nil {
+				dropped |= webpFlagEXIF
+				chunk = nil
+				break
+			}
+			chunk = bytes.NewReader(data)
+		case typ == "EXIF":
+			dropped |= webpFlagEXIF
+			chunk = nil
+		case typ == "XMP ":
+			chunk = nil
+		case typ == "VP8X" && length > 0:
+			flags = size + 8
+		}
+
+		if chunk == nil {
+			if _, err := io.CopyN(io.Discard, src, length); err != nil {
+				return truncated(err)
+			}
+		} else {
+			if _, err := tmp.Write(header[:8]); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(tmp, chunk, length); err != nil {
+				return truncated(err)
+			}
+			size += 8 + length
+		}
+
+		// chunks are padded to an even size, the padding of the last one can be missing
+		if length%2 == 1 {
+			n, err := io.CopyN(io.Discard, src, 1)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if n == 1 && chunk != nil {
+				if _, err := tmp.Write([]byte{0}); err != nil {
+					return err
+				}
+				size++
+			}
+		}
+	}
+
+	binary.LittleEndian.PutUint32(header[4:8], uint32(size-8))
+	if _, err := tmp.WriteAt(header[4:8], 4); err != nil {
+		return err
+	}
+
+	if flags >= 0 {
+		flag := header[:1]
+		if _, err := tmp.ReadAt(flag, flags); err != nil {
+			return err
+		}
+		flag[0] &^= dropped
+		if _, err := tmp.WriteAt(flag, flags); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, tmp)
+	return err
+}
@@ -0,0 +1,182 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gpsTIFF is little-endian EXIF with a camera make and a GPS latitude stored out of its entry
+func gpsTIFF() []byte {
+	le := binary.LittleEndian
+	data := make([]byte, 98)
+	copy(data, "II")
+	le.PutUint16(data[2:], 42)
+	le.PutUint32(data[4:], 8)
+
+	// IFD0: make and the GPS IFD
+	le.PutUint16(data[8:], 2)
+	entry := func(at int, tag, typ uint16, count, value uint32) {
+		le.PutUint16(data[at:], tag)
+		le.PutUint16(data[at+2:], typ)
+		le.PutUint32(data[at+4:], count)
+		le.PutUint32(data[at+8:], value)
+	}
+	entry(10, TAG_MAKE, 2, 4, 0)
+	copy(data[18:], "Cam\x00")
+	entry(22, TAG_GPS_IFD, 4, 1, 38)
+
+	// GPS IFD at 38: the reference and three rationals at 74
+	le.PutUint16(data[38:], 2)
+	entry(40, TAG_GPS_LATITUDE_REF, 2, 2, 0)
+	copy(data[48:], "N\x00")
+	entry(52, TAG_GPS_LATITUDE, 5, 3, 74)
+	for i, value := range []uint32{52, 30, 15} {
+		le.PutUint32(data[74+i*8:], value)
+		le.PutUint32(data[78+i*8:], 1)
+	}
+
+	return data
+}
+
+func jpegSegment(marker byte, data []byte) []byte {
+	segment := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(data)+2))
+	return append(segment, data...)
+}
+
+// jpegFile is SOI, the segments, a scan and EOI
+func jpegFile(segments ...[]byte) []byte {
+	file := []byte{0xff, 0xd8}
+	for _, segment := range segments {
+		file = append(file, segment...)
+	}
+	file = append(file, jpegSegment(0xda, []byte{1, 2, 3})...)
+	return append(file, 0xaa, 0xbb, 0xff, 0x00, 0xcc, 0xff, 0xd9)
+}
+
+func pngChunk(typ string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func pngFile(chunks ...[]byte) []byte {
+	file := append([]byte{}, pngSignature...)
+	file = append(file, pngChunk("IHDR", make([]byte, 13))...)
+	for _, chunk := range chunks {
+		file = append(file, chunk...)
+	}
+	file = append(file, pngChunk("IDAT", []byte{1, 2, 3})...)
+	return append(file, pngChunk("IEND", nil)...)
+}
+
+func TestScrub(t *testing.T) {
+	exifSegment := jpegSegment(0xe1, append([]byte("Exif\x00\x00"), gpsTIFF()...))
+	xmpSegment := jpegSegment(0xe1, append(append([]byte{}, jpegXMPHeader...), "<gps/>"...))
+	comment := jpegSegment(0xfe, []byte("taken at home"))
+
+	tests := []struct {
+		name   string
+		file   []byte
+		policy string
+		// whether EXIF is left, its position never is
+		exif bool
+		// found is what the scrubbed file must still contain, gone what it mustn't
+		found, gone [][]byte
+		err         error
+	}{
+		{name: "jpeg", file: jpegFile(exifSegment, comment), policy: STRIP_GPS, exif: true,
+			found: [][]byte{[]byte("taken at home"), {0xaa, 0xbb, 0xff, 0x00, 0xcc}}},
+		{name: "jpeg with fill bytes", file: jpegFile(append([]byte{0xff, 0xff}, exifSegment...), xmpSegment), policy: STRIP_GPS,
+			exif: true, gone: [][]byte{[]byte("<gps/>")}},
+		{name: "jpeg stripped", file: jpegFile(exifSegment, xmpSegment, comment), policy: STRIP_ALL,
+			gone: [][]byte{[]byte("Exif"), []byte("<gps/>"), []byte("taken at home")}},
+		{name: "truncated jpeg", file: append([]byte{0xff, 0xd8}, exifSegment[:40]...), policy: STRIP_GPS, err: ErrMalformed},
+		{name: "jpeg cut before its scan", file: append([]byte{0xff, 0xd8}, comment...), policy: STRIP_GPS, err: ErrMalformed},
+		{name: "jpeg with garbage between segments", file: append(append([]byte{0xff, 0xd8}, 0x12, 0x34), exifSegment...),
+			policy: STRIP_GPS, err: ErrMalformed},
+		{name: "jpeg with a bad length", file: []byte{0xff, 0xd8, 0xff, 0xe1, 0x00, 0x01}, policy: STRIP_GPS, err: ErrMalformed},
+
+		{name: "png", file: pngFile(pngChunk("eXIf", gpsTIFF()), pngChunk("tEXt", []byte("Comment\x00taken at home"))),
+			policy: STRIP_GPS, exif: true, found: [][]byte{[]byte("taken at home")}},
+		{name: "png xmp", file: pngFile(pngChunk("iTXt", append(append([]byte{}, pngXMPKeyword...), "\x00\x00\x00\x00<gps/>"...))),
+			policy: STRIP_GPS, gone: [][]byte{[]byte("<gps/>")}},
+		{name: "png stripped", file: pngFile(pngChunk("eXIf", gpsTIFF()), pngChunk("iTXt", []byte("Comment\x00\x00\x00\x00\x00taken at home"))),
+			policy: STRIP_ALL, gone: [][]byte{[]byte("Cam"), []byte("taken at home")}},
+		{name: "png with data after its end", file: append(pngFile(), exifSegment...), policy: STRIP_GPS, gone: [][]byte{[]byte("Exif")}},
+		{name: "truncated png", file: pngFile(pngChunk("eXIf", gpsTIFF()))[:60], policy: STRIP_GPS, err: ErrMalformed},
+
+		{name: "webp", file: webpFile(webpChunk("VP8L", []byte("pixels")), webpChunk("EXIF", gpsTIFF()), webpChunk("XMP ", []byte("<gps/>"))),
+			policy: STRIP_GPS, exif: true, found: [][]byte{[]byte("pixels")}, gone: [][]byte{[]byte("<gps/>")}},
+		{name: "webp stripped", file: webpFile(webpChunk("VP8L", []byte("pixels")), webpChunk("EXIF", gpsTIFF())),
+			policy: STRIP_ALL, found: [][]byte{[]byte("pixels")}, gone: [][]byte{[]byte("Cam")}},
+		{name: "truncated webp", file: webpFile(webpChunk("VP8L", []byte("pixels")))[:20], policy: STRIP_GPS, err: ErrMalformed},
+		{name: "webp with a bad length", file: webpFile(append(webpChunk("EXIF", nil)[:4], 0xff, 0xff, 0, 0)), policy: STRIP_GPS, err: ErrMalformed},
+
+		{name: "other files", file: []byte("BM is not enough"), policy: STRIP_ALL, found: [][]byte{[]byte("BM is not enough")}},
+		{name: "invalid policy", file: jpegFile(), policy: "strip_some", err: ErrInvalidPolicy},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var scrubbed bytes.Buffer
+			err := Scrub(&scrubbed, bytes.NewReader(test.file), test.policy)
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+
+			raw, err := Extract(bytes.NewReader(scrubbed.Bytes()))
+			if !test.exif {
+				assert.ErrorIs(t, err, ErrNotFound, "EXIF is left")
+			} else {
+				require.NoError(t, err, "EXIF is dropped")
+				data, err := Parse(raw)
+				require.NoError(t, err, "The scrubbed EXIF can't be parsed")
+				assert.Equal(t, "Cam", data.Make, "Other EXIF tags aren't kept")
+				assert.Nil(t, data.Latitude, "The position is left")
+			}
+
+			for _, found := range test.found {
+				assert.True(t, bytes.Contains(scrubbed.Bytes(), found), "%q is dropped", found)
+			}
+			for _, gone := range test.gone {
+				assert.False(t, bytes.Contains(scrubbed.Bytes(), gone), "%q is left", gone)
+			}
+		})
+	}
+}
+
+func TestScrubKeep(t *testing.T) {
+	file := jpegFile(jpegSegment(0xe1, append([]byte("Exif\x00\x00"), gpsTIFF()...)))
+
+	var kept bytes.Buffer
+	require.NoError(t, Scrub(&kept, bytes.NewReader(file), KEEP))
+	assert.Equal(t, file, kept.Bytes(), "The file is changed")
+}
+
+func TestScrubWebP(t *testing.T) {
+	// the EXIF and XMP flags of VP8X go with their chunks, the RIFF size is the one of what's left
+	vp8x := make([]byte, 10)
+	vp8x[0] = webpFlagEXIF | webpFlagXMP | 0x10
+	pixels := webpChunk("VP8L", []byte("odd"))
+	file := webpFile(webpChunk("VP8X", vp8x), pixels, webpChunk("EXIF", gpsTIFF()), webpChunk("XMP ", []byte("<gps/>")))
+
+	var scrubbed bytes.Buffer
+	require.NoError(t, Scrub(&scrubbed, bytes.NewReader(file), STRIP_ALL))
+
+	vp8x[0] = 0x10
+	assert.Equal(t, webpFile(webpChunk("VP8X", vp8x), pixels), scrubbed.Bytes())
+
+	// the padding of the last chunk can be missing
+	scrubbed.Reset()
+	require.NoError(t, Scrub(&scrubbed, bytes.NewReader(webpFile(pixels[:len(pixels)-1])), STRIP_ALL))
+	assert.Equal(t, webpFile(pixels[:len(pixels)-1]), scrubbed.Bytes())
+}
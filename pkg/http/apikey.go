@@ -0,0 +1,109 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// API keys look like ak_<id>_<secret>, only a hash of the secret is stored
+const (
+	API_KEY_PREFIX = "ak_"
+
+	apiKeyIDLen     = 8
+	apiKeySecretLen = 32
+)
+
+type apiKey struct {
+	ID        string   `gorm:"type:varchar(32);primaryKey"`
+	Hash      string   `gorm:"type:char(64);not null"`
+	Subject   string   `gorm:"type:varchar(255);not null;index"`
+	Roles     []string `gorm:"type:jsonb;serializer:json"`
+	ExpiresAt *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+func (apiKey) TableName() string {
+	return "api_keys"
+}
+
+type APIKeyStore struct {
+	conn *gorm.DB
+}
+
+func NewAPIKeyStore(conn *gorm.DB) (*APIKeyStore, error) {
+	if err := conn.AutoMigrate(&apiKey{}); err != nil {
+		return nil, err
+	}
+
+	return &APIKeyStore{conn: conn}, nil
+}
+
+// Create issues a key for the subject, it can't be read back later.
+// Keys without an expiration time are valid until they're revoked.
+func (s *APIKeyStore) Create(ctx context.Context, subject string, roles []string, expiresAt *time.Time) (string, error) {
+	id := make([]byte, apiKeyIDLen)
+	secret := make([]byte, apiKeySecretLen)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	key := apiKey{
+		ID:        hex.EncodeToString(id),
+		Hash:      hashSecret(hex.EncodeToString(secret)),
+		Subject:   subject,
+		Roles:     roles,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.conn.WithContext(ctx).Create(&key).Error; err != nil {
+		return "", err
+	}
+
+	return API_KEY_PREFIX + key.ID + "_" + hex.EncodeToString(secret), nil
+}
+
+func (s *APIKeyStore) Revoke(ctx context.Context, id string) error {
+	return s.conn.WithContext(ctx).Model(&apiKey{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", time.Now()).Error
+}
+
+func (s *APIKeyStore) Verify(ctx context.Context, key string) (Principal, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(key, API_KEY_PREFIX), "_")
+	if !ok || !strings.HasPrefix(key, API_KEY_PREFIX) {
+		return Principal{}, ErrInvalidCredentials
+	}
+
+	var stored apiKey
+	err := s.conn.WithContext(ctx).Where("id = ?", id).First(&stored).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Principal{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(stored.Hash)) != 1 {
+		return Principal{}, ErrInvalidCredentials
+	}
+	if stored.RevokedAt != nil || (stored.ExpiresAt != nil && time.Now().After(*stored.ExpiresAt)) {
+		return Principal{}, ErrInvalidCredentials
+	}
+
+	return Principal{Subject: stored.Subject, Roles: stored.Roles, Method: AUTH_API_KEY}, nil
+}
+
+// secrets are random, so a fast hash is enough to keep them out of the database
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
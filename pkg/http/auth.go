@@ -0,0 +1,101 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// authentication methods
+const (
+	AUTH_API_KEY = "api_key"
+	AUTH_JWT     = "jwt"
+
+	API_KEY_HEADER = "X-API-Key"
+
+	ROLE_ADMIN = "admin"
+)
+
+var (
+	ErrNoCredentials      = errors.New("no credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is who a request is made by
+type Principal struct {
+	Subject string
+	Roles   []string
+	Method  string // api_key or jwt
+}
+
+func (p Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal of an authenticated request
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+type Authenticator interface {
+	// Authenticate returns ErrNoCredentials for anonymous requests.
+	Authenticate(req *http.Request) (Principal, error)
+}
+
+// Auth accepts API keys in the X-API-Key header or as bearer tokens, and JWT bearer tokens
+// when a key to verify them is configured
+type Auth struct {
+	keys   *APIKeyStore
+	tokens *tokenVerifier
+}
+
+func NewAuth(conf AuthConfig, db *gorm.DB) (*Auth, error) {
+	keys, err := NewAPIKeyStore(db)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := newTokenVerifier(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Auth{keys: keys, tokens: tokens}, nil
+}
+
+func (a *Auth) Keys() *APIKeyStore {
+	return a.keys
+}
+
+func (a *Auth) Authenticate(req *http.Request) (Principal, error) {
+	if key := req.Header.Get(API_KEY_HEADER); key != "" {
+		return a.keys.Verify(req.Context(), key)
+	}
+
+	scheme, credentials, _ := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || credentials == "" {
+		return Principal{}, ErrNoCredentials
+	}
+
+	credentials = strings.TrimSpace(credentials)
+	if strings.HasPrefix(credentials, API_KEY_PREFIX) {
+		return a.keys.Verify(req.Context(), credentials)
+	}
+
+	if a.tokens == nil {
+		return Principal{}, ErrInvalidCredentials
+	}
+
+	return a.tokens.Verify(credentials)
+}
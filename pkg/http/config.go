@@ -1,6 +1,27 @@
 package http 
 
+import "time"
+
+// images never change under their id, so they can be cached for long
+const DEFAULT_CACHE_CONTROL = "public, max-age=86400"
+
+// images not everyone sees are kept out of shared caches, CacheControl is for public ones
+const PRIVATE_CACHE_CONTROL = "private"
+
 type Config struct {
 	Host string
 	Port uint16
-}
\ No newline at end of file
+	CacheControl string
+	Timeout time.Duration // of every request, uploads included, unlimited when 0
+	ShutdownTimeout time.Duration // how long requests in flight are waited for on shutdown
+	Auth AuthConfig
+}
+
+// AuthConfig holds the keys JWT bearer tokens are verified with, API keys are stored in db
+type AuthConfig struct {
+	HMACSecret       string // HS256 tokens
+	RSAPublicKeyFile string // PEM file of the key for RS256 tokens
+	Issuer           string
+	Audience         string
+	Leeway           time.Duration // allowed clock skew
+}
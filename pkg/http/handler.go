@@ -1,9 +1,5 @@
 package http
 
-import (
-	"net/http"
-)
-
 type Handler interface {
-	Register(mux *http.ServeMux)
+	Register(router *Router)
 }
\ No newline at end of file
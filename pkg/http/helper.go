@@ -1,10 +1,16 @@
 package http
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 )
 
+const (
+	DISPOSITION_INLINE     = "inline"
+	DISPOSITION_ATTACHMENT = "attachment"
+)
+
 func GetPath(path string) string {
 	return "GET " + path
 }
@@ -13,6 +19,26 @@ func PostPath(path string) string {
 	return "POST " + path
 }
 
+func HeadPath(path string) string {
+	return "HEAD " + path
+}
+
+func PutPath(path string) string {
+	return "PUT " + path
+}
+
+func PatchPath(path string) string {
+	return "PATCH " + path
+}
+
+func DeletePath(path string) string {
+	return "DELETE " + path
+}
+
+func OptionsPath(path string) string {
+	return "OPTIONS " + path
+}
+
 func WriteResponse(resp http.ResponseWriter, statusCode int, explanations ...string) {
 	resp.WriteHeader(statusCode)
 	resp.Write([]byte(http.StatusText(statusCode)))
@@ -24,3 +50,43 @@ func WriteResponse(resp http.ResponseWriter, statusCode int, explanations ...str
 	}
 }
 
+
+// ContentDisposition formats a Content-Disposition value (RFC 6266) with an ASCII filename
+// for old clients and the original one in UTF-8 as the filename* parameter (RFC 5987)
+func ContentDisposition(disposition, filename string) string {
+	if filename == "" {
+		return disposition
+	}
+
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r >= 0x7f || r == '"' || r == '\\' || r == '%' {
+			return '_'
+		}
+		return r
+	}, filename)
+
+	value := fmt.Sprintf(`%s; filename="%s"`, disposition, fallback)
+	if fallback == filename {
+		return value
+	}
+
+	var encoded strings.Builder
+	for _, b := range []byte(filename) {
+		if isAttrChar(b) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+
+	return value + "; filename*=UTF-8''" + encoded.String()
+}
+
+func isAttrChar(b byte) bool {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		return true
+	}
+
+	return strings.IndexByte("!#$&+-.^_`|~", b) >= 0
+}
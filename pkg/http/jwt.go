@@ -0,0 +1,115 @@
+package http
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MIN_SECRET_LEN is the shortest HMAC secret accepted, HS256 keys are 256 bits
+const MIN_SECRET_LEN = 32
+
+var ErrWeakSecret = errors.New("secret is a placeholder or shorter than 32 bytes")
+
+// placeholders are what example configs ship instead of a secret
+var placeholders = []string{"change me", "changeme", "change-me", "change_me", "replace me", "placeholder", "secret"}
+
+// CheckSecret refuses HMAC secrets anyone could guess, like placeholders copied from an example config
+func CheckSecret(secret string) error {
+	if len(secret) < MIN_SECRET_LEN {
+		return ErrWeakSecret
+	}
+
+	lower := strings.ToLower(secret)
+	for _, placeholder := range placeholders {
+		if strings.Contains(lower, placeholder) {
+			return ErrWeakSecret
+		}
+	}
+
+	return nil
+}
+
+type claims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles,omitempty"`
+}
+
+type tokenVerifier struct {
+	parser    *jwt.Parser
+	secret    []byte
+	publicKey *rsa.PublicKey
+}
+
+// newTokenVerifier returns nil when no key is configured, so tokens aren't accepted
+func newTokenVerifier(conf AuthConfig) (*tokenVerifier, error) {
+	v := &tokenVerifier{}
+
+	var methods []string
+	if conf.HMACSecret != "" {
+		if err := CheckSecret(conf.HMACSecret); err != nil {
+			return nil, fmt.Errorf("HMACSecret: %w", err)
+		}
+		v.secret = []byte(conf.HMACSecret)
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+
+	if conf.RSAPublicKeyFile != "" {
+		pem, err := os.ReadFile(conf.RSAPublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		v.publicKey, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", conf.RSAPublicKeyFile, err)
+		}
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+
+	if len(methods) == 0 {
+		return nil, nil
+	}
+
+	// only the configured algorithms are accepted, so a public key can't be used as an HMAC secret
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(conf.Leeway),
+	}
+	if conf.Issuer != "" {
+		options = append(options, jwt.WithIssuer(conf.Issuer))
+	}
+	if conf.Audience != "" {
+		options = append(options, jwt.WithAudience(conf.Audience))
+	}
+	v.parser = jwt.NewParser(options...)
+
+	return v, nil
+}
+
+func (v *tokenVerifier) Verify(token string) (Principal, error) {
+	var c claims
+
+	_, err := v.parser.ParseWithClaims(token, &c, v.key)
+	if err != nil || c.Subject == "" {
+		return Principal{}, ErrInvalidCredentials
+	}
+
+	return Principal{Subject: c.Subject, Roles: c.Roles, Method: AUTH_JWT}, nil
+}
+
+func (v *tokenVerifier) key(token *jwt.Token) (any, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return v.secret, nil
+	case *jwt.SigningMethodRSA:
+		return v.publicKey, nil
+	}
+
+	return nil, jwt.ErrTokenUnverifiable
+}
@@ -0,0 +1,144 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const DEFAULT_SHUTDOWN_TIMEOUT = 30 * time.Second
+
+type worker struct {
+	name string
+	run  func(ctx context.Context)
+}
+
+type closer struct {
+	name  string
+	close func() error
+}
+
+// Lifecycle runs the server with its background workers until a signal comes,
+// then stops them in order: the server stops accepting connections and drains
+// requests in flight, the workers are stopped, the resources are closed.
+type Lifecycle struct {
+	srv     *http.Server
+	timeout time.Duration
+	workers []worker
+	closers []closer
+}
+
+func NewLifecycle(srv *http.Server, conf Config) *Lifecycle {
+	timeout := conf.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DEFAULT_SHUTDOWN_TIMEOUT
+	}
+
+	return &Lifecycle{srv: srv, timeout: timeout}
+}
+
+// Go runs the worker while the server is serving, its context is done once requests are drained
+func (l *Lifecycle) Go(name string, run func(ctx context.Context)) {
+	l.workers = append(l.workers, worker{name: name, run: run})
+}
+
+// OnStop registers a resource to close after the workers stopped.
+// Resources are closed in the reverse order, like deferred calls.
+func (l *Lifecycle) OnStop(name string, close func() error) {
+	l.closers = append(l.closers, closer{name: name, close: close})
+}
+
+// Run serves until SIGINT or SIGTERM comes or ctx is done, then shuts everything down.
+// Requests still running after the shutdown timeout are cut off.
+func (l *Lifecycle) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		running = make(map[string]int)
+	)
+	for _, w := range l.workers {
+		running[w.name]++
+	}
+	for _, w := range l.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run(workersCtx)
+
+			mu.Lock()
+			running[w.name]--
+			mu.Unlock()
+		}()
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- l.srv.ListenAndServe()
+	}()
+
+	var errs []error
+	select {
+	case <-ctx.Done():
+		log.Println("Shutting down, draining requests in flight")
+	case err := <-serveErr:
+		// the server couldn't start, the rest is stopped all the same
+		if !errors.Is(err, http.ErrServerClosed) {
+			errs = append(errs, err)
+		}
+	}
+	// a second signal kills the process the default way
+	stop()
+
+	deadline, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+
+	if err := l.srv.Shutdown(deadline); err != nil {
+		errs = append(errs, fmt.Errorf("draining requests: %w", err))
+		l.srv.Close()
+	}
+
+	stopWorkers()
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-deadline.Done():
+		mu.Lock()
+		var names []string
+		for name, n := range running {
+			if n > 0 {
+				names = append(names, name)
+			}
+		}
+		mu.Unlock()
+		slices.Sort(names)
+		errs = append(errs, fmt.Errorf("stopping workers: timed out waiting for %s", strings.Join(names, ", ")))
+	}
+
+	for i := len(l.closers) - 1; i >= 0; i-- {
+		if err := l.closers[i].close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing %s: %w", l.closers[i].name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
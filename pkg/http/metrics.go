@@ -0,0 +1,107 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"testapp/pkg/metrics"
+)
+
+// UNMATCHED_ROUTE labels requests no route matched, so made up paths don't add series
+const UNMATCHED_ROUTE = "unmatched"
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.NAMESPACE,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Requests served, by route and status code.",
+	}, []string{"method", "route", "code"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.NAMESPACE,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to serve requests, by route.",
+		// uploads take minutes
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"method", "route"})
+
+	responseSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.NAMESPACE,
+		Subsystem: "http",
+		Name:      "response_size_bytes",
+		Help:      "Size of response bodies, by route.",
+		Buckets:   prometheus.ExponentialBuckets(256, 4, 8),
+	}, []string{"method", "route"})
+
+	requestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.NAMESPACE,
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "Requests being served.",
+	})
+)
+
+type routeKey struct{}
+
+// Instrument records the count, duration and response size of requests.
+// Requests are labeled by the pattern of their route, never by their path.
+func Instrument() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			recorder, ok := resp.(*responseRecorder)
+			if !ok {
+				recorder = &responseRecorder{ResponseWriter: resp}
+			}
+
+			route := UNMATCHED_ROUTE
+			method := methodLabel(req.Method)
+			requestsInFlight.Inc()
+			defer func() {
+				requestsInFlight.Dec()
+				requestsTotal.WithLabelValues(method, route, strconv.Itoa(recorder.Status())).Inc()
+				requestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+				responseSize.WithLabelValues(method, route).Observe(float64(recorder.written))
+			}()
+
+			next.ServeHTTP(recorder, req.WithContext(context.WithValue(req.Context(), routeKey{}, &route)))
+		})
+	}
+}
+
+// setRoute tells Instrument and the request's span the pattern of the route serving the request,
+// without its method
+func setRoute(req *http.Request, pattern string) {
+	if _, path, found := strings.Cut(pattern, " "); found {
+		pattern = path
+	}
+
+	span := trace.SpanFromContext(req.Context())
+	span.SetName(methodLabel(req.Method) + " " + pattern)
+	span.SetAttributes(semconv.HTTPRoute(pattern))
+
+	if route, ok := req.Context().Value(routeKey{}).(*string); ok {
+		*route = pattern
+	}
+}
+
+// methodLabel keeps methods clients make up out of the labels
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return method
+	}
+
+	return "OTHER"
+}
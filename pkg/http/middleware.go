@@ -0,0 +1,209 @@
+package http
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"testapp/pkg/tracing"
+)
+
+const (
+	REQUEST_ID_HEADER = "X-Request-ID"
+	// longer ids sent by clients are replaced, so they can't flood the logs
+	MAX_REQUEST_ID_LEN = 128
+)
+
+// Middleware wraps a handler to run code around it
+type Middleware func(next http.Handler) http.Handler
+
+// Chain wraps h with the middlewares, the first one is the outermost
+func Chain(h http.Handler, mm ...Middleware) http.Handler {
+	for i := len(mm) - 1; i >= 0; i-- {
+		h = mm[i](h)
+	}
+
+	return h
+}
+
+type requestIDKey struct{}
+
+// RequestIDFrom returns the id RequestID gave the request
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestID passes on the X-Request-ID a client or proxy sent, or makes one up,
+// and returns it with the response
+func RequestID() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			id := req.Header.Get(REQUEST_ID_HEADER)
+			if !validRequestID(id) {
+				id = uuid.NewString()
+			}
+
+			resp.Header().Set(REQUEST_ID_HEADER, id)
+			next.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id)))
+		})
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > MAX_REQUEST_ID_LEN {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Recover answers 500 when a handler panics instead of dropping the connection,
+// the panic is logged with its stack
+func Recover() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			recorder, ok := resp.(*responseRecorder)
+			if !ok {
+				recorder = &responseRecorder{ResponseWriter: resp}
+			}
+
+			defer func() {
+				err := recover()
+				if err == nil {
+					return
+				}
+				// the server aborts the response without logging it
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
+				log.Printf("Panic serving %s %s (request %s): %v\n%s", req.Method, req.URL.Path, RequestIDFrom(req.Context()), err, debug.Stack())
+				if !recorder.wroteHeader {
+					WriteError(recorder, req, Internal("", nil))
+				}
+			}()
+
+			next.ServeHTTP(recorder, req)
+		})
+	}
+}
+
+// AccessLog logs every request with its status, response size and duration
+func AccessLog(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			recorder, ok := resp.(*responseRecorder)
+			if !ok {
+				recorder = &responseRecorder{ResponseWriter: resp}
+			}
+
+			defer func() {
+				// the trace is there when the request was traced, to find its spans from the log
+				trace := ""
+				if id := tracing.TraceIDFrom(req.Context()); id != "" {
+					trace = " trace=" + id
+				}
+				logger.Printf("%s %s %s %d %d %s%s", RequestIDFrom(req.Context()), req.Method, req.URL.RequestURI(),
+					recorder.Status(), recorder.written, time.Since(start).Round(time.Microsecond), trace)
+			}()
+
+			next.ServeHTTP(recorder, req)
+		})
+	}
+}
+
+// Timeout cancels the request's context after d. The response isn't buffered,
+// so streaming downloads aren't held back, handlers stop when they see the context is done.
+func Timeout(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			ctx, cancel := context.WithTimeout(req.Context(), d)
+			defer cancel()
+
+			next.ServeHTTP(resp, req.WithContext(ctx))
+		})
+	}
+}
+
+// BodyLimit rejects requests with bodies larger than n bytes, those declaring
+// their length before anything is read
+func BodyLimit(n int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			if req.ContentLength > n {
+				WriteError(resp, req, NewError(http.StatusRequestEntityTooLarge, CODE_TOO_LARGE, "Request body is too large, the limit is "+strconv.FormatInt(n, 10)+" bytes"))
+				return
+			}
+
+			req.Body = http.MaxBytesReader(resp, req.Body, n)
+			next.ServeHTTP(resp, req)
+		})
+	}
+}
+
+// responseRecorder remembers the status and size of a response for the middlewares
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	written     int64
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader && status >= http.StatusOK {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+
+	n, err := r.ResponseWriter.Write(p)
+	r.written += int64(n)
+	return n, err
+}
+
+func (r *responseRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+
+	return r.status
+}
+
+// Unwrap lets http.ResponseController reach the connection's writer for flushing and deadlines
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *responseRecorder) Flush() {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(r.ResponseWriter).Flush()
+}
@@ -0,0 +1,165 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	PROBLEM_CONTENT_TYPE = "application/problem+json"
+	// problem types name the codes, they aren't meant to be dereferenced
+	PROBLEM_TYPE_PREFIX = "urn:testapp:problem:"
+)
+
+// codes are stable, clients branch on them rather than on the details
+const (
+	CODE_INVALID_INPUT        = "invalid_input"
+	CODE_NOT_FOUND            = "not_found"
+	CODE_TOO_LARGE            = "too_large"
+	CODE_UNSUPPORTED_TYPE     = "unsupported_type"
+	CODE_MALFORMED            = "malformed"
+	CODE_CONFLICT             = "conflict"
+	CODE_GONE                 = "gone"
+	CODE_QUOTA_EXCEEDED       = "quota_exceeded"
+	CODE_INSUFFICIENT_STORAGE = "insufficient_storage"
+	CODE_BATCH_ABORTED        = "batch_aborted"
+	CODE_PRECONDITION_FAILED  = "precondition_failed"
+	CODE_UNAUTHENTICATED      = "unauthenticated"
+	CODE_FORBIDDEN            = "forbidden"
+	CODE_UNAVAILABLE          = "unavailable"
+	CODE_INTERNAL             = "internal"
+)
+
+// FieldError tells which part of the input is wrong
+type FieldError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+// Error is an error clients are told about. Detail is sent as is, so it mustn't
+// carry anything internal, Err is only logged.
+type Error struct {
+	Status int
+	Code   string
+	Detail string
+	Fields []FieldError
+	Err    error
+}
+
+func NewError(status int, code, detail string, fields ...FieldError) *Error {
+	return &Error{Status: status, Code: code, Detail: detail, Fields: fields}
+}
+
+func InvalidInput(detail string, fields ...FieldError) *Error {
+	return NewError(http.StatusBadRequest, CODE_INVALID_INPUT, detail, fields...)
+}
+
+func NotFound(detail string) *Error {
+	return NewError(http.StatusNotFound, CODE_NOT_FOUND, detail)
+}
+
+// Internal describes a failure clients can't do anything about, err stays in the logs
+func Internal(detail string, err error) *Error {
+	return &Error{Status: http.StatusInternalServerError, Code: CODE_INTERNAL, Detail: detail, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Detail + ": " + e.Err.Error()
+	}
+
+	return e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Problem is the body of an error response (RFC 9457)
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// WriteError answers with the problem err describes, as problem+json to clients asking for JSON
+// and as plain text to the others. Errors that aren't an *Error are internal, their details are logged only.
+func WriteError(resp http.ResponseWriter, req *http.Request, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = Internal("", err)
+	}
+
+	requestID := RequestIDFrom(req.Context())
+	if e.Status >= http.StatusInternalServerError && e.Err != nil {
+		log.Printf("Error serving %s %s (request %s): %v", req.Method, req.URL.Path, requestID, e.Err)
+	}
+
+	resp.Header().Add("Vary", "Accept")
+	if !WantsProblem(req) {
+		explanations := []string{}
+		if e.Detail != "" {
+			explanations = append(explanations, e.Detail)
+		}
+		for _, field := range e.Fields {
+			explanations = append(explanations, field.Field+": "+field.Detail)
+		}
+
+		resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		WriteResponse(resp, e.Status, explanations...)
+		return
+	}
+
+	problem := Problem{
+		Type:      PROBLEM_TYPE_PREFIX + e.Code,
+		Title:     http.StatusText(e.Status),
+		Status:    e.Status,
+		Detail:    e.Detail,
+		Instance:  req.URL.Path,
+		Code:      e.Code,
+		RequestID: requestID,
+		Errors:    e.Fields,
+	}
+
+	resp.Header().Set("Content-Type", PROBLEM_CONTENT_TYPE)
+	resp.WriteHeader(e.Status)
+	json.NewEncoder(resp).Encode(problem)
+}
+
+// WantsProblem tells whether the client prefers JSON errors to plain text. Clients not asking
+// for JSON, like those written before errors were problems, get plain text.
+func WantsProblem(req *http.Request) bool {
+	var problem, text float64
+	for _, accepted := range strings.Split(strings.Join(req.Header.Values("Accept"), ","), ",") {
+		mediaType, params, err := mime.ParseMediaType(accepted)
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+
+		switch mediaType {
+		case PROBLEM_CONTENT_TYPE, "application/json", "application/*":
+			problem = max(problem, q)
+		case "text/plain", "text/*", "*/*":
+			text = max(text, q)
+		}
+	}
+
+	return problem > 0 && problem >= text
+}
@@ -0,0 +1,137 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+)
+
+var (
+	ErrUnauthenticated = errors.New("authentication required")
+	ErrForbidden       = errors.New("permission denied")
+)
+
+// Requirement decides whether a request may use a route, anonymous requests aren't ok
+type Requirement func(principal Principal, ok bool) error
+
+// Public routes can be used without credentials
+func Public(Principal, bool) error {
+	return nil
+}
+
+func Authenticated(_ Principal, ok bool) error {
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	return nil
+}
+
+func RequireRole(role string) Requirement {
+	return func(principal Principal, ok bool) error {
+		if !ok {
+			return ErrUnauthenticated
+		}
+		if !principal.HasRole(role) {
+			return ErrForbidden
+		}
+
+		return nil
+	}
+}
+
+// Router registers handlers with the requirements of their routes. Groups of a router
+// share its routes, the middlewares of a group wrap only the routes registered with it.
+type Router struct {
+	mux         *http.ServeMux
+	auth        Authenticator
+	parent      *Router
+	middlewares []Middleware
+}
+
+// NewRouter returns a router authenticating requests with auth, without it every request is anonymous
+func NewRouter(mux *http.ServeMux, auth Authenticator) *Router {
+	return &Router{mux: mux, auth: auth}
+}
+
+// Use adds middlewares to the routes registered afterwards. The ones of the root router
+// run for every request, unknown routes included, before it's authenticated.
+// The ones of groups run after authentication, so they see the principal.
+func (r *Router) Use(mm ...Middleware) {
+	r.middlewares = append(r.middlewares, mm...)
+}
+
+// Group returns a router for routes sharing middlewares, in addition to the ones of r
+func (r *Router) Group(mm ...Middleware) *Router {
+	return &Router{mux: r.mux, auth: r.auth, parent: r, middlewares: append([]Middleware(nil), mm...)}
+}
+
+// With is Group for a single route, router.With(BodyLimit(n)).HandleFunc(...)
+func (r *Router) With(mm ...Middleware) *Router {
+	return r.Group(mm...)
+}
+
+// ServeHTTP runs the middlewares of the root router around the routes
+func (r *Router) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	Chain(r.mux, r.middlewares...).ServeHTTP(resp, req)
+}
+
+// Handle registers the handler for the pattern. Routes declared without requirements need authentication.
+func (r *Router) Handle(pattern string, handler http.Handler, requirements ...Requirement) {
+	if len(requirements) == 0 {
+		requirements = []Requirement{Authenticated}
+	}
+
+	// the innermost group's middlewares are the closest to the handler
+	for g := r; g.parent != nil; g = g.parent {
+		handler = Chain(handler, g.middlewares...)
+	}
+
+	r.mux.Handle(pattern, http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		setRoute(req, pattern)
+
+		principal, err := r.authenticate(req)
+		ok := err == nil
+		// wrong credentials aren't taken for none, even on public routes
+		if err != nil && !errors.Is(err, ErrNoCredentials) {
+			if errors.Is(err, ErrInvalidCredentials) {
+				unauthorized(resp, req, err)
+			} else {
+				WriteError(resp, req, Internal("Error checking credentials", err))
+			}
+			return
+		}
+
+		for _, requirement := range requirements {
+			if err := requirement(principal, ok); err != nil {
+				if errors.Is(err, ErrForbidden) {
+					WriteError(resp, req, NewError(http.StatusForbidden, CODE_FORBIDDEN, err.Error()))
+				} else {
+					unauthorized(resp, req, err)
+				}
+				return
+			}
+		}
+
+		if ok {
+			req = req.WithContext(WithPrincipal(req.Context(), principal))
+		}
+		handler.ServeHTTP(resp, req)
+	}))
+}
+
+func (r *Router) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request), requirements ...Requirement) {
+	r.Handle(pattern, http.HandlerFunc(handler), requirements...)
+}
+
+func (r *Router) authenticate(req *http.Request) (Principal, error) {
+	if r.auth == nil {
+		return Principal{}, ErrNoCredentials
+	}
+
+	return r.auth.Authenticate(req)
+}
+
+func unauthorized(resp http.ResponseWriter, req *http.Request, err error) {
+	resp.Header().Set("WWW-Authenticate", `Bearer realm="testapp"`)
+	WriteError(resp, req, NewError(http.StatusUnauthorized, CODE_UNAUTHENTICATED, err.Error()))
+}
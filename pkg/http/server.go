@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"path/filepath"
 )
 
 var srv *http.Server							   
@@ -20,24 +19,34 @@ func FileExists(filename string) bool {
     return !os.IsNotExist(err)
 }
 
-func ReadFile(FILENAME string) ([]byte, error) {
-	fileBytes, err := os.ReadFile(filepath.Join("assets", "tmp", FILENAME))
-	if err != nil {
-		return nil, err
-	}
-	return fileBytes, nil
-} 
-
-func NewServer(conf Config, hh ...Handler) *http.Server {
+// NewServer registers the handlers, auth authenticates requests to their routes.
+// Every handler registers its routes with a group of its own, so middlewares it uses
+// don't wrap routes of the others.
+func NewServer(conf Config, auth Authenticator, hh ...Handler) *http.Server {
 	mux := NewMux()
+	router := NewRouter(mux, auth)
+	router.Use(Middlewares(conf)...)
 	for _, h := range hh {
-		h.Register(mux)
+		h.Register(router.Group())
 	}
 
 	srv = &http.Server{
 		Addr: fmt.Sprintf(":%v", conf.Port),
-		Handler: mux,
+		Handler: router,
 	}
 
 	return srv 
+}
+
+// Middlewares are run for every request. Panics are recovered inside the access log,
+// the metrics and the span, so failed requests are recorded as 500.
+func Middlewares(conf Config) []Middleware {
+	return []Middleware{
+		RequestID(),
+		Trace(),
+		Instrument(),
+		AccessLog(nil),
+		Recover(),
+		Timeout(conf.Timeout),
+	}
 }
\ No newline at end of file
@@ -0,0 +1,47 @@
+package http
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("testapp/pkg/http")
+
+// Trace starts a span for every request, as a child of the one the client sent
+// in traceparent. The span is named after the route once it's matched.
+func Trace() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+			method := methodLabel(req.Method)
+			ctx, span := tracer.Start(ctx, method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(method),
+				semconv.URLPath(req.URL.Path),
+				semconv.ClientAddress(req.RemoteAddr),
+				semconv.UserAgentOriginal(req.UserAgent()),
+				attribute.String("request.id", RequestIDFrom(req.Context())),
+			))
+			defer span.End()
+
+			recorder, ok := resp.(*responseRecorder)
+			if !ok {
+				recorder = &responseRecorder{ResponseWriter: resp}
+			}
+
+			next.ServeHTTP(recorder, req.WithContext(ctx))
+
+			status := recorder.Status()
+			span.SetAttributes(semconv.HTTPResponseStatusCode(status), semconv.HTTPResponseBodySize(int(recorder.written)))
+			// 4xx are the client's errors, the server span only fails on 5xx
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package imaging
+
+import (
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/HugoSmits86/nativewebp"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+)
+
+const (
+	PNG  = "png"
+	JPEG = "jpeg"
+	GIF  = "gif"
+	WEBP = "webp"
+
+	// decoding is refused above this, so a tiny file can't claim gigapixels
+	MaxPixels = 64 << 20
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrTooManyPixels     = errors.New("image has too many pixels")
+)
+
+var contentTypes = map[string]string{
+	PNG:  "image/png",
+	JPEG: "image/jpeg",
+	GIF:  "image/gif",
+	WEBP: "image/webp",
+}
+
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// FormatOf returns the encodable format of a content type or "" if there is none
+func FormatOf(contentType string) string {
+	for format, ct := range contentTypes {
+		if ct == contentType {
+			return format
+		}
+	}
+
+	return ""
+}
+
+// Decode reads PNG, JPEG, GIF, WebP, BMP and TIFF images
+func Decode(r io.ReadSeeker) (image.Image, error) {
+	config, _, err := image.DecodeConfig(r)
+	if errors.Is(err, image.ErrFormat) {
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(config.Width)*int64(config.Height) > MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(r)
+	return img, err
+}
+
+// Encode writes the image in format, quality is only used by JPEG.
+// WebP is always encoded losslessly.
+func Encode(w io.Writer, img image.Image, format string, quality int) error {
+	switch format {
+	case PNG:
+		return png.Encode(w, img)
+	case JPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case GIF:
+		return gif.Encode(w, img, nil)
+	case WEBP:
+		return nativewebp.Encode(w, img, nil)
+	}
+
+	return ErrUnsupportedFormat
+}
@@ -0,0 +1,51 @@
+package imaging
+
+import "time"
+
+const (
+	DEFAULT_CACHE_DIR = "assets/cache"
+	DEFAULT_WORKERS   = 2
+	DEFAULT_RETENTION = 30 * 24 * time.Hour
+	DEFAULT_LINK_TTL  = 24 * time.Hour
+	MAX_LINK_TTL      = 30 * 24 * time.Hour
+)
+
+type Config struct {
+	CacheDir     string
+	AllowedTypes []string
+	Metadata     string // keep, strip_gps or strip_all
+	Collision    string // reject, overwrite, suffix or uuid, when an upload is named like a stored file
+	Workers      int
+	Renditions   []Rendition
+	Retention    time.Duration // how long deleted images can be restored
+	Links        LinkConfig
+	Quota        QuotaConfig
+}
+
+// QuotaConfig limits what is stored, zero values don't limit anything
+type QuotaConfig struct {
+	Bytes      int64 // per user
+	Objects    int64 // per user
+	TotalBytes int64 // of all users together
+}
+
+// LinkConfig is how share links are signed and how long they last
+type LinkConfig struct {
+	Secret string // links are signed with a random key when it's empty, they don't survive restarts then
+	TTL    time.Duration
+	MaxTTL time.Duration
+}
+
+// Rendition is a named variant generated for every uploaded image
+type Rendition struct {
+	Name    string
+	Width   int
+	Height  int
+	Fit     string
+	Format  string
+	Quality int
+}
+
+func (r Rendition) Options() Options {
+	return Options{Width: r.Width, Height: r.Height, Fit: r.Fit, Format: r.Format, Quality: r.Quality}
+}
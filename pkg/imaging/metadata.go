@@ -0,0 +1,209 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+)
+
+type Metadata struct {
+	Width      int
+	Height     int
+	ColorModel string
+	Frames     int
+}
+
+// ReadMetadata reads dimensions and color model from the header and counts animation frames
+func ReadMetadata(r io.ReadSeeker) (Metadata, error) {
+	config, format, err := image.DecodeConfig(bufio.NewReader(r))
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	m := Metadata{
+		Width:      config.Width,
+		Height:     config.Height,
+		ColorModel: colorModelName(config.ColorModel),
+		Frames:     1,
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return Metadata{}, err
+	}
+
+	var frames int
+	switch format {
+	case GIF:
+		frames, err = gifFrames(bufio.NewReader(r))
+	case PNG:
+		frames, err = pngFrames(r)
+	case WEBP:
+		frames, err = webpFrames(r)
+	}
+	if err != nil {
+		return Metadata{}, err
+	}
+	if frames > 0 {
+		m.Frames = frames
+	}
+
+	return m, nil
+}
+
+func colorModelName(model color.Model) string {
+	switch model {
+	case color.RGBAModel:
+		return "rgba"
+	case color.RGBA64Model:
+		return "rgba64"
+	case color.NRGBAModel:
+		return "nrgba"
+	case color.NRGBA64Model:
+		return "nrgba64"
+	case color.GrayModel:
+		return "gray"
+	case color.Gray16Model:
+		return "gray16"
+	case color.AlphaModel:
+		return "alpha"
+	case color.Alpha16Model:
+		return "alpha16"
+	case color.CMYKModel:
+		return "cmyk"
+	case color.YCbCrModel:
+		return "ycbcr"
+	case color.NYCbCrAModel:
+		return "nycbcra"
+	}
+
+	if _, ok := model.(color.Palette); ok {
+		return "paletted"
+	}
+
+	return ""
+}
+
+// gifFrames counts image descriptors without decoding them
+func gifFrames(r *bufio.Reader) (int, error) {
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+
+	// global color table
+	if header[10]&0x80 != 0 {
+		if _, err := r.Discard(3 << (header[10]&0x07 + 1)); err != nil {
+			return 0, err
+		}
+	}
+
+	frames := 0
+	for {
+		introducer, err := r.ReadByte()
+		if err != nil {
+			return frames, nil
+		}
+
+		switch introducer {
+		case 0x21:
+			if _, err := r.Discard(1); err != nil {
+				return frames, err
+			}
+		case 0x2c:
+			frames++
+
+			descriptor := make([]byte, 9)
+			if _, err := io.ReadFull(r, descriptor); err != nil {
+				return frames, err
+			}
+
+			// local color table and LZW minimum code size
+			skip := 1
+			if descriptor[8]&0x80 != 0 {
+				skip += 3 << (descriptor[8]&0x07 + 1)
+			}
+			if _, err := r.Discard(skip); err != nil {
+				return frames, err
+			}
+		default:
+			// trailer or garbage
+			return frames, nil
+		}
+
+		if err := skipSubBlocks(r); err != nil {
+			return frames, err
+		}
+	}
+}
+
+func skipSubBlocks(r *bufio.Reader) error {
+	for {
+		size, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		if _, err := r.Discard(int(size)); err != nil {
+			return err
+		}
+	}
+}
+
+// pngFrames reads the frame count of animated PNGs from the acTL chunk
+func pngFrames(r io.ReadSeeker) (int, error) {
+	if _, err := r.Seek(8, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return 0, nil
+		}
+
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		switch string(header[4:]) {
+		case "acTL":
+			frames := make([]byte, 4)
+			if _, err := io.ReadFull(r, frames); err != nil {
+				return 0, err
+			}
+			return int(binary.BigEndian.Uint32(frames)), nil
+		case "IDAT", "IEND":
+			return 1, nil
+		}
+
+		if _, err := r.Seek(length+4, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// webpFrames counts ANMF chunks of animated WebP files
+func webpFrames(r io.ReadSeeker) (int, error) {
+	if _, err := r.Seek(12, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	frames := 0
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return frames, nil
+		}
+
+		if bytes.Equal(header[:4], []byte("ANMF")) {
+			frames++
+		}
+
+		length := int64(binary.LittleEndian.Uint32(header[4:]))
+		if _, err := r.Seek(length+length%2, io.SeekCurrent); err != nil {
+			return frames, err
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encoded(t *testing.T, encode func(*bytes.Buffer) error) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, encode(&buf))
+	return buf.Bytes()
+}
+
+func gifFile(t *testing.T, frames int, localPalette bool) []byte {
+	animation := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 4, 3), palette.Plan9)
+		if localPalette {
+			frame.Palette = color.Palette{color.Black, color.White}
+		}
+		frame.SetColorIndex(i%4, 0, 1)
+		animation.Image = append(animation.Image, frame)
+		animation.Delay = append(animation.Delay, 10)
+	}
+
+	return encoded(t, func(buf *bytes.Buffer) error { return gif.EncodeAll(buf, animation) })
+}
+
+// the signature and IHDR with its length, type and crc
+const afterIHDR = 8 + 4 + 4 + 13 + 4
+
+// withChunk inserts the chunk into a PNG file at the given offset
+func withChunk(file []byte, at int, typ string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	return append(append(append([]byte{}, file[:at]...), chunk...), file[at:]...)
+}
+
+// withFrames appends ANMF chunks to an extended WebP file
+func withFrames(file []byte, frames int) []byte {
+	file = append([]byte{}, file...)
+	// the VP8X flags, animated
+	file[20] |= 0x02
+
+	for i := 0; i < frames; i++ {
+		file = binary.LittleEndian.AppendUint32(append(file, "ANMF"...), 17)
+		file = append(file, make([]byte, 18)...)
+	}
+	binary.LittleEndian.PutUint32(file[4:], uint32(len(file)-8))
+
+	return file
+}
+
+func TestReadMetadata(t *testing.T) {
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 5, 3))
+	gray := image.NewGray(image.Rect(0, 0, 7, 2))
+	pngFile := encoded(t, func(buf *bytes.Buffer) error { return png.Encode(buf, nrgba) })
+	acTL := []byte{0, 0, 0, 4, 0, 0, 0, 0}
+	webpFile := encoded(t, func(buf *bytes.Buffer) error {
+		return nativewebp.Encode(buf, nrgba, &nativewebp.Options{UseExtendedFormat: true})
+	})
+
+	tests := []struct {
+		name     string
+		file     []byte
+		metadata Metadata
+	}{
+		{name: "png", file: pngFile, metadata: Metadata{Width: 5, Height: 3, ColorModel: "nrgba", Frames: 1}},
+		{name: "animated png", file: withChunk(pngFile, afterIHDR, "acTL", acTL),
+			metadata: Metadata{Width: 5, Height: 3, ColorModel: "nrgba", Frames: 4}},
+		// acTL only counts before the image data, IEND is the last 12 bytes
+		{name: "png with acTL after its data", file: withChunk(pngFile, len(pngFile)-12, "acTL", acTL),
+			metadata: Metadata{Width: 5, Height: 3, ColorModel: "nrgba", Frames: 1}},
+		{name: "jpeg", file: encoded(t, func(buf *bytes.Buffer) error { return jpeg.Encode(buf, gray, nil) }),
+			metadata: Metadata{Width: 7, Height: 2, ColorModel: "gray", Frames: 1}},
+		{name: "gif", file: gifFile(t, 1, false), metadata: Metadata{Width: 4, Height: 3, ColorModel: "paletted", Frames: 1}},
+		{name: "animated gif", file: gifFile(t, 3, false), metadata: Metadata{Width: 4, Height: 3, ColorModel: "paletted", Frames: 3}},
+		{name: "gif with local palettes", file: gifFile(t, 5, true), metadata: Metadata{Width: 4, Height: 3, ColorModel: "paletted", Frames: 5}},
+		// extended WebP files are described by their VP8X header
+		{name: "webp", file: webpFile, metadata: Metadata{Width: 5, Height: 3, ColorModel: "nycbcra", Frames: 1}},
+		{name: "animated webp", file: withFrames(webpFile, 3), metadata: Metadata{Width: 5, Height: 3, ColorModel: "nycbcra", Frames: 3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metadata, err := ReadMetadata(bytes.NewReader(test.file))
+			require.NoError(t, err)
+			assert.Equal(t, test.metadata, metadata)
+		})
+	}
+}
+
+func TestReadMetadataInvalid(t *testing.T) {
+	_, err := ReadMetadata(bytes.NewReader([]byte("%PDF-1.7 not an image")))
+	assert.ErrorIs(t, err, image.ErrFormat)
+
+	// the frames are counted from a file cut in the middle of its second frame
+	animation := gifFile(t, 2, true)
+	_, err = ReadMetadata(bytes.NewReader(animation[:len(animation)-8]))
+	assert.Error(t, err)
+}
@@ -0,0 +1,130 @@
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+const (
+	FIT_CONTAIN = "contain"
+	FIT_COVER   = "cover"
+	FIT_FILL    = "fill"
+
+	MaxDimension   = 8192
+	DefaultQuality = 85
+)
+
+var (
+	ErrInvalidOptions = errors.New("invalid transformation options")
+	// ErrOutputTooLarge is invalid options as well, those asking for more than can be allocated
+	ErrOutputTooLarge = fmt.Errorf("%w: the output is too large", ErrInvalidOptions)
+)
+
+type Options struct {
+	Width   int
+	Height  int
+	Fit     string
+	Format  string
+	Quality int
+	Rotate  int
+}
+
+// ParseOptions reads ?w=300&h=200&fit=cover&format=webp&q=80&rotate=90
+func ParseOptions(query url.Values) (o Options, err error) {
+	if o.Width, err = intParam(query, "w", 0, MaxDimension); err != nil {
+		return Options{}, err
+	}
+	if o.Height, err = intParam(query, "h", 0, MaxDimension); err != nil {
+		return Options{}, err
+	}
+	if o.Quality, err = intParam(query, "q", 1, 100); err != nil {
+		return Options{}, err
+	}
+	if o.Rotate, err = intParam(query, "rotate", -270, 270); err != nil {
+		return Options{}, err
+	}
+
+	o.Rotate = (o.Rotate%360 + 360) % 360
+	if o.Rotate%90 != 0 {
+		return Options{}, fmt.Errorf("%w: rotate must be a multiple of 90", ErrInvalidOptions)
+	}
+
+	o.Fit = query.Get("fit")
+	switch o.Fit {
+	case "", FIT_CONTAIN, FIT_COVER, FIT_FILL:
+	default:
+		return Options{}, fmt.Errorf("%w: unknown fit %q", ErrInvalidOptions, o.Fit)
+	}
+
+	o.Format = query.Get("format")
+	if o.Format == "jpg" {
+		o.Format = JPEG
+	}
+	if o.Format != "" && ContentType(o.Format) == "" {
+		return Options{}, fmt.Errorf("%w: unknown format %q", ErrInvalidOptions, o.Format)
+	}
+
+	if err := o.Check(""); err != nil {
+		return Options{}, err
+	}
+
+	return o, nil
+}
+
+// Check refuses options that can't be honored for images of the source content type.
+// WebP is encoded losslessly, so a quality asked for it would be ignored.
+func (o Options) Check(sourceContentType string) error {
+	if o.Quality != 0 && o.Normalize(sourceContentType).Format == WEBP {
+		return fmt.Errorf("%w: q isn't supported for webp, which is encoded losslessly", ErrInvalidOptions)
+	}
+
+	return nil
+}
+
+func (o Options) IsZero() bool {
+	return o == Options{}
+}
+
+// Normalize fills in defaults, so equivalent requests share one cache key
+func (o Options) Normalize(sourceContentType string) Options {
+	if o.Format == "" {
+		o.Format = FormatOf(sourceContentType)
+		if o.Format == "" {
+			o.Format = PNG
+		}
+	}
+
+	if o.Width == 0 || o.Height == 0 {
+		o.Fit = ""
+	} else if o.Fit == "" {
+		o.Fit = FIT_CONTAIN
+	}
+
+	if o.Format != JPEG {
+		o.Quality = 0
+	} else if o.Quality == 0 {
+		o.Quality = DefaultQuality
+	}
+
+	return o
+}
+
+func (o Options) Key() string {
+	return fmt.Sprintf("w=%d,h=%d,fit=%s,format=%s,q=%d,rotate=%d", o.Width, o.Height, o.Fit, o.Format, o.Quality, o.Rotate)
+}
+
+func intParam(query url.Values, name string, min, max int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%w: %s must be between %d and %d", ErrInvalidOptions, name, min, max)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,55 @@
+package imaging
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		query   string
+		options Options
+		invalid bool
+	}{
+		{query: "", options: Options{}},
+		{query: "w=300&h=200&fit=cover&format=jpg&q=80&rotate=-90",
+			options: Options{Width: 300, Height: 200, Fit: FIT_COVER, Format: JPEG, Quality: 80, Rotate: 270}},
+		{query: "format=webp", options: Options{Format: WEBP}},
+		{query: "w=0", options: Options{}},
+		{query: "w=8193", invalid: true},
+		{query: "h=-1", invalid: true},
+		{query: "q=0", invalid: true},
+		{query: "rotate=45", invalid: true},
+		{query: "fit=stretch", invalid: true},
+		{query: "format=avif", invalid: true},
+		// webp is encoded losslessly, the quality would be ignored
+		{query: "format=webp&q=80", invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			query, err := url.ParseQuery(test.query)
+			require.NoError(t, err)
+
+			options, err := ParseOptions(query)
+			if test.invalid {
+				assert.ErrorIs(t, err, ErrInvalidOptions)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.options, options)
+		})
+	}
+}
+
+func TestOptionsCheck(t *testing.T) {
+	quality := Options{Quality: 80}
+
+	assert.ErrorIs(t, quality.Check("image/webp"), ErrInvalidOptions, "A quality for a webp source is accepted")
+	assert.NoError(t, quality.Check("image/jpeg"))
+	assert.NoError(t, Options{Format: JPEG, Quality: 80}.Check("image/webp"), "A webp source converted to jpeg has no quality")
+	assert.NoError(t, Options{}.Check("image/webp"))
+}
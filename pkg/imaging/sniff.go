@@ -0,0 +1,131 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// SNIFF_LEN is how many leading bytes DetectContentType looks at
+const SNIFF_LEN = 512
+
+var DEFAULT_ALLOWED_TYPES = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"image/bmp",
+	"image/tiff",
+}
+
+var signatures = []struct {
+	offset      int
+	magic       []byte
+	contentType string
+}{
+	{0, []byte("\x89PNG\r\n\x1a\n"), "image/png"},
+	{0, []byte("\xff\xd8\xff"), "image/jpeg"},
+	{0, []byte("GIF87a"), "image/gif"},
+	{0, []byte("GIF89a"), "image/gif"},
+	{8, []byte("WEBP"), "image/webp"},
+	{0, []byte("BM"), "image/bmp"},
+	{0, []byte("II*\x00"), "image/tiff"},
+	{0, []byte("MM\x00*"), "image/tiff"},
+	{0, []byte("\x00\x00\x01\x00"), "image/x-icon"},
+	{0, []byte("%PDF-"), "application/pdf"},
+}
+
+// ftyp brands of ISO base media files
+var brands = map[string]string{
+	"avif": "image/avif",
+	"avis": "image/avif",
+	"heic": "image/heic",
+	"heix": "image/heic",
+	"mif1": "image/heif",
+	"msf1": "image/heif",
+}
+
+// sizes of the DIB headers following the BMP file header, from BITMAPCOREHEADER to BITMAPV5HEADER
+var dibHeaderSizes = []uint32{12, 40, 52, 56, 108, 124}
+
+var aliases = map[string]string{
+	"image/jpg":                "image/jpeg",
+	"image/pjpeg":              "image/jpeg",
+	"image/x-png":              "image/png",
+	"image/x-ms-bmp":           "image/bmp",
+	"image/vnd.microsoft.icon": "image/x-icon",
+}
+
+// DetectContentType recognizes files by their signatures instead of names or client claims
+func DetectContentType(head []byte) string {
+	for _, sig := range signatures {
+		if len(head) >= sig.offset+len(sig.magic) && bytes.Equal(head[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+			// RIFF containers other than WebP share the offset with it
+			if sig.contentType == "image/webp" && !bytes.HasPrefix(head, []byte("RIFF")) {
+				continue
+			}
+			// plenty of text starts with "BM", bitmaps go on with a known DIB header
+			if sig.contentType == "image/bmp" && !isBMP(head) {
+				continue
+			}
+			return sig.contentType
+		}
+	}
+
+	if len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")) {
+		if contentType, ok := brands[string(head[8:12])]; ok {
+			return contentType
+		}
+	}
+
+	if isSVG(head) {
+		return "image/svg+xml"
+	}
+
+	// the standard library knows bitmaps by "BM" alone
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if contentType == "image/bmp" {
+		return "application/octet-stream"
+	}
+	return contentType
+}
+
+// NormalizeContentType drops parameters and maps aliases, "" means nothing specific was declared
+func NormalizeContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "application/octet-stream" {
+		return ""
+	}
+
+	if alias, ok := aliases[mediaType]; ok {
+		return alias
+	}
+
+	return mediaType
+}
+
+func isBMP(head []byte) bool {
+	if len(head) < 18 {
+		return false
+	}
+
+	return slices.Contains(dibHeaderSizes, binary.LittleEndian.Uint32(head[14:18]))
+}
+
+func isSVG(head []byte) bool {
+	text := bytes.TrimSpace(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")))
+
+	// skip the XML declaration, comments and doctype before the root element
+	for bytes.HasPrefix(text, []byte("<?")) || bytes.HasPrefix(text, []byte("<!")) {
+		end := bytes.IndexByte(text, '>')
+		if end < 0 {
+			return false
+		}
+		text = bytes.TrimSpace(text[end+1:])
+	}
+
+	return strings.HasPrefix(strings.ToLower(string(text)), "<svg")
+}
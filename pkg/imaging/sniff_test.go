@@ -0,0 +1,47 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/bmp"
+)
+
+// bmpHead is a BMP file header followed by the size of its DIB header
+func bmpHead(dibSize uint32) []byte {
+	head := append([]byte("BM"), make([]byte, 12)...)
+	return binary.LittleEndian.AppendUint32(head, dibSize)
+}
+
+func TestDetectContentType(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := bmp.Encode(&encoded, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		head        []byte
+		contentType string
+	}{
+		"bmp":                 {head: encoded.Bytes(), contentType: "image/bmp"},
+		"os/2 bmp":            {head: bmpHead(12), contentType: "image/bmp"},
+		"v5 bmp":              {head: bmpHead(124), contentType: "image/bmp"},
+		"text starting in BM": {head: []byte("BMW drivers are invited to the meeting"), contentType: "application/octet-stream"},
+		"unknown dib header":  {head: bmpHead(64), contentType: "application/octet-stream"},
+		"short bmp":           {head: []byte("BM\x00\x01"), contentType: "application/octet-stream"},
+		"png":                 {head: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"), contentType: "image/png"},
+		"webp":                {head: []byte("RIFF\x00\x00\x00\x00WEBPVP8L"), contentType: "image/webp"},
+		"wave":                {head: []byte("RIFF\x00\x00\x00\x00WAVEfmt "), contentType: "audio/wave"},
+		"svg":                 {head: []byte("<?xml version=\"1.0\"?>\n<svg xmlns=\"http://www.w3.org/2000/svg\"/>"), contentType: "image/svg+xml"},
+		"avif":                {head: []byte("\x00\x00\x00\x1cftypavif"), contentType: "image/avif"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.contentType, DetectContentType(test.head))
+		})
+	}
+}
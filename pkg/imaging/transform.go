@@ -0,0 +1,148 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Transform rotates and resizes img by o. Resizing to more than MaxDimension on a side
+// or MaxPixels in total fails with ErrOutputTooLarge before anything is allocated.
+func Transform(img image.Image, o Options) (image.Image, error) {
+	bounds := img.Bounds()
+	width, height, err := OutputSize(bounds.Dx(), bounds.Dy(), o)
+	if err != nil {
+		return nil, err
+	}
+
+	img = rotate(img, o.Rotate)
+	if o.Width == 0 && o.Height == 0 {
+		return img, nil
+	}
+
+	bounds = img.Bounds()
+	if o.Width != 0 && o.Height != 0 && o.Fit == FIT_COVER {
+		// crop the source to the target aspect ratio around its center
+		scale := math.Max(float64(width)/float64(bounds.Dx()), float64(height)/float64(bounds.Dy()))
+		cropW, cropH := int(math.Round(float64(width)/scale)), int(math.Round(float64(height)/scale))
+		x0 := bounds.Min.X + (bounds.Dx()-cropW)/2
+		y0 := bounds.Min.Y + (bounds.Dy()-cropH)/2
+		bounds = image.Rect(x0, y0, x0+cropW, y0+cropH)
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+
+	return dst, nil
+}
+
+// OutputSize is the size of a width x height image transformed by o,
+// ErrOutputTooLarge when it exceeds MaxDimension on a side or MaxPixels in total
+func OutputSize(width, height int, o Options) (int, int, error) {
+	if o.Rotate == 90 || o.Rotate == 270 {
+		width, height = height, width
+	}
+	// the image is only rotated, it has as many pixels as the one decoded
+	if o.Width == 0 && o.Height == 0 {
+		return width, height, nil
+	}
+	srcW, srcH := float64(width), float64(height)
+
+	// sides are kept as floats until they are checked, extreme aspect ratios overflow ints
+	outW, outH := float64(o.Width), float64(o.Height)
+	switch {
+	case o.Height == 0:
+		outH = math.Round(srcH * outW / srcW)
+	case o.Width == 0:
+		outW = math.Round(srcW * outH / srcH)
+	case o.Fit == FIT_CONTAIN:
+		scale := math.Min(outW/srcW, outH/srcH)
+		outW, outH = math.Round(srcW*scale), math.Round(srcH*scale)
+	}
+	outW, outH = math.Max(outW, 1), math.Max(outH, 1)
+
+	if outW > MaxDimension || outH > MaxDimension || outW*outH > MaxPixels {
+		return 0, 0, fmt.Errorf("%w: %.0fx%.0f", ErrOutputTooLarge, outW, outH)
+	}
+
+	return int(outW), int(outH), nil
+}
+
+func rotate(img image.Image, degrees int) image.Image {
+	if degrees == 0 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	var dst *image.NRGBA
+	if degrees == 180 {
+		dst = image.NewNRGBA(image.Rect(0, 0, w, h))
+	} else {
+		dst = image.NewNRGBA(image.Rect(0, 0, h, w))
+	}
+
+	// degrees are clockwise
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+			switch degrees {
+			case 90:
+				dst.Set(h-1-y, x, c)
+			case 180:
+				dst.Set(w-1-x, h-1-y, c)
+			case 270:
+				dst.Set(y, w-1-x, c)
+			}
+		}
+	}
+
+	return dst
+}
+
+// Orient applies an EXIF orientation to the pixels, so the image displays upright without it
+func Orient(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 3:
+		return rotate(img, 180)
+	case 6:
+		return rotate(img, 90)
+	case 8:
+		return rotate(img, 270)
+	case 2, 4, 5, 7:
+	default:
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	var dst *image.NRGBA
+	if orientation == 5 || orientation == 7 {
+		dst = image.NewNRGBA(image.Rect(0, 0, h, w))
+	} else {
+		dst = image.NewNRGBA(image.Rect(0, 0, w, h))
+	}
+
+	// the mirrored orientations
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+			switch orientation {
+			case 2:
+				dst.Set(w-1-x, y, c)
+			case 4:
+				dst.Set(x, h-1-y, c)
+			case 5:
+				dst.Set(y, x, c)
+			case 7:
+				dst.Set(h-1-y, w-1-x, c)
+			}
+		}
+	}
+
+	return dst
+}
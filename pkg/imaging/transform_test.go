@@ -0,0 +1,163 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// thirds is a width x height image, red on its left third, green in the middle and blue on the right
+func thirds(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		c := color.NRGBA{B: 255, A: 255}
+		if x < width/3 {
+			c = color.NRGBA{R: 255, A: 255}
+		} else if x < 2*width/3 {
+			c = color.NRGBA{G: 255, A: 255}
+		}
+		for y := 0; y < height; y++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		name   string
+		width  int
+		height int
+		o      Options
+		size   image.Point
+	}{
+		{name: "unchanged", width: 30, height: 20, o: Options{}, size: image.Pt(30, 20)},
+		{name: "width", width: 30, height: 20, o: Options{Width: 15}, size: image.Pt(15, 10)},
+		{name: "height", width: 30, height: 20, o: Options{Height: 40}, size: image.Pt(60, 40)},
+		{name: "contain", width: 30, height: 20, o: Options{Width: 12, Height: 12, Fit: FIT_CONTAIN}, size: image.Pt(12, 8)},
+		{name: "cover", width: 30, height: 20, o: Options{Width: 12, Height: 12, Fit: FIT_COVER}, size: image.Pt(12, 12)},
+		{name: "fill", width: 30, height: 20, o: Options{Width: 12, Height: 12, Fit: FIT_FILL}, size: image.Pt(12, 12)},
+		{name: "rotate 90", width: 30, height: 20, o: Options{Rotate: 90}, size: image.Pt(20, 30)},
+		{name: "rotate 180", width: 30, height: 20, o: Options{Rotate: 180}, size: image.Pt(30, 20)},
+		{name: "rotate and width", width: 30, height: 20, o: Options{Width: 10, Rotate: 270}, size: image.Pt(10, 15)},
+		// the other side is at least a pixel
+		{name: "width of a narrow image", width: 1, height: 4000, o: Options{Height: 100}, size: image.Pt(1, 100)},
+		{name: "height of a flat image", width: 4000, height: 1, o: Options{Width: 100}, size: image.Pt(100, 1)},
+		{name: "narrow image up to the limit", width: 1, height: 8, o: Options{Width: 1024}, size: image.Pt(1024, MaxDimension)},
+		{name: "contain of a narrow image", width: 1, height: 4000, o: Options{Width: 500, Height: 500, Fit: FIT_CONTAIN}, size: image.Pt(1, 500)},
+		{name: "cover of a narrow image", width: 1, height: 4000, o: Options{Width: 500, Height: 500, Fit: FIT_COVER}, size: image.Pt(500, 500)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transformed, err := Transform(image.NewGray(image.Rect(0, 0, test.width, test.height)), test.o)
+			require.NoError(t, err)
+			assert.Equal(t, test.size, transformed.Bounds().Size())
+
+			width, height, err := OutputSize(test.width, test.height, test.o)
+			require.NoError(t, err)
+			assert.Equal(t, test.size, image.Pt(width, height), "OutputSize differs from the transformed image")
+		})
+	}
+}
+
+func TestTransformCrop(t *testing.T) {
+	// the cover of a square keeps the middle third, fill keeps all of them
+	covered, err := Transform(thirds(90, 30), Options{Width: 10, Height: 10, Fit: FIT_COVER})
+	require.NoError(t, err)
+	for _, x := range []int{0, 5, 9} {
+		assert.Equal(t, color.NRGBA{G: 255, A: 255}, covered.At(x, 5), "The cover isn't the center at %d", x)
+	}
+
+	filled, err := Transform(thirds(90, 30), Options{Width: 9, Height: 9, Fit: FIT_FILL})
+	require.NoError(t, err)
+	assert.Equal(t, color.NRGBA{R: 255, A: 255}, filled.At(0, 4))
+	assert.Equal(t, color.NRGBA{B: 255, A: 255}, filled.At(8, 4))
+}
+
+func TestTransformRotate(t *testing.T) {
+	// degrees are clockwise, the left third ends up on the top for 90
+	tests := map[int]struct {
+		red, blue image.Point
+	}{
+		90:  {red: image.Pt(0, 0), blue: image.Pt(0, 2)},
+		180: {red: image.Pt(2, 0), blue: image.Pt(0, 0)},
+		270: {red: image.Pt(0, 2), blue: image.Pt(0, 0)},
+	}
+
+	for degrees, test := range tests {
+		t.Run(strconv.Itoa(degrees), func(t *testing.T) {
+			rotated, err := Transform(thirds(3, 1), Options{Rotate: degrees})
+			require.NoError(t, err)
+			assert.Equal(t, color.NRGBA{R: 255, A: 255}, rotated.At(test.red.X, test.red.Y))
+			assert.Equal(t, color.NRGBA{B: 255, A: 255}, rotated.At(test.blue.X, test.blue.Y))
+		})
+	}
+}
+
+func TestTransformEncode(t *testing.T) {
+	transformed, err := Transform(thirds(60, 40), Options{Width: 30})
+	require.NoError(t, err)
+
+	for _, format := range []string{PNG, JPEG, GIF, WEBP} {
+		t.Run(format, func(t *testing.T) {
+			var encoded bytes.Buffer
+			require.NoError(t, Encode(&encoded, transformed, format, DefaultQuality))
+
+			config, decodedFormat, err := image.DecodeConfig(&encoded)
+			require.NoError(t, err)
+			assert.Equal(t, format, decodedFormat)
+			assert.Equal(t, 30, config.Width)
+			assert.Equal(t, 20, config.Height)
+		})
+	}
+
+	assert.ErrorIs(t, Encode(io.Discard, transformed, "avif", 0), ErrUnsupportedFormat)
+
+	// the quality is JPEG's only
+	size := func(format string, quality int) int {
+		var encoded bytes.Buffer
+		require.NoError(t, Encode(&encoded, transformed, format, quality))
+		return encoded.Len()
+	}
+	assert.Less(t, size(JPEG, 10), size(JPEG, 100), "A lower quality isn't smaller")
+	assert.Equal(t, size(PNG, 10), size(PNG, 100), "The quality changes a PNG")
+}
+
+func TestTransformTooLarge(t *testing.T) {
+	tests := []struct {
+		name   string
+		width  int
+		height int
+		o      Options
+	}{
+		// would be 8192x32768000, about a terabyte
+		{name: "width of a narrow image", width: 1, height: 4000, o: Options{Width: 8192}},
+		{name: "height of a flat image", width: 60000, height: 1, o: Options{Height: 1024}},
+		{name: "width of a rotated flat image", width: 4000, height: 1, o: Options{Width: 8192, Rotate: 90}},
+		{name: "contain of a narrow image", width: 1, height: 60000, o: Options{Width: 9000, Height: 9000, Fit: FIT_CONTAIN}},
+		{name: "sides over the limit", width: 10, height: 10, o: Options{Width: MaxDimension + 1, Height: 10, Fit: FIT_FILL}},
+		{name: "area over the limit", width: 10, height: 10, o: Options{Width: MaxDimension, Height: MaxDimension + 1, Fit: FIT_COVER}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Transform(image.NewGray(image.Rect(0, 0, test.width, test.height)), test.o)
+			assert.ErrorIs(t, err, ErrOutputTooLarge)
+			assert.ErrorIs(t, err, ErrInvalidOptions)
+		})
+	}
+}
+
+func TestTransformRotateOnly(t *testing.T) {
+	// sides over MaxDimension are fine when the image isn't resized, it's as large as it was decoded
+	transformed, err := Transform(image.NewGray(image.Rect(0, 0, 1, MaxDimension+1)), Options{Rotate: 90})
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, MaxDimension+1, 1), transformed.Bounds())
+}
@@ -0,0 +1,8 @@
+package metrics
+
+const DEFAULT_PATH = "/metrics"
+
+type Config struct {
+	Path   string
+	Public bool // scraped without credentials, otherwise admins only
+}
@@ -0,0 +1,21 @@
+package metrics
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// NAMESPACE prefixes the names of the app's metrics
+const NAMESPACE = "testapp"
+
+// Handler serves the metrics of the default registry in the Prometheus text format.
+// A failing collector doesn't fail the scrape, the others are served all the same.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+		ErrorLog:      log.Default(),
+		ErrorHandling: promhttp.ContinueOnError,
+	})
+}
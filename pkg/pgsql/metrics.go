@@ -0,0 +1,121 @@
+package pgsql
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"gorm.io/gorm"
+
+	"testapp/pkg/metrics"
+)
+
+const (
+	queryStartKey = "metrics:query_start"
+	// SIZE_TIMEOUT bounds the size query, so a slow database doesn't hold scrapes up
+	SIZE_TIMEOUT = 5 * time.Second
+)
+
+var queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: metrics.NAMESPACE,
+	Subsystem: "db",
+	Name:      "query_duration_seconds",
+	Help:      "Time taken by queries, by operation and table.",
+	Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+}, []string{"operation", "table"})
+
+var sizeDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(metrics.NAMESPACE, "database", "size_bytes"),
+	"Size of the database on disk.",
+	nil, nil,
+)
+
+// Instrument times the queries run through db and exports the stats of its pool
+// and the size of the database, labeled with name
+func Instrument(db *gorm.DB, name string) error {
+	callbacks := db.Callback()
+	err := errors.Join(
+		callbacks.Create().Before("*").Register("metrics:before_create", startQuery),
+		callbacks.Create().After("*").Register("metrics:after_create", observeQuery("create")),
+		callbacks.Query().Before("*").Register("metrics:before_query", startQuery),
+		callbacks.Query().After("*").Register("metrics:after_query", observeQuery("query")),
+		callbacks.Update().Before("*").Register("metrics:before_update", startQuery),
+		callbacks.Update().After("*").Register("metrics:after_update", observeQuery("update")),
+		callbacks.Delete().Before("*").Register("metrics:before_delete", startQuery),
+		callbacks.Delete().After("*").Register("metrics:after_delete", observeQuery("delete")),
+		callbacks.Row().Before("*").Register("metrics:before_row", startQuery),
+		callbacks.Row().After("*").Register("metrics:after_row", observeQuery("row")),
+		callbacks.Raw().Before("*").Register("metrics:before_raw", startQuery),
+		callbacks.Raw().After("*").Register("metrics:after_raw", observeQuery("raw")),
+	)
+	if err != nil {
+		return err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	for _, collector := range []prometheus.Collector{
+		queryDuration,
+		collectors.NewDBStatsCollector(sqlDB, name),
+		sizeCollector{db: db},
+	} {
+		if err := prometheus.Register(collector); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func startQuery(db *gorm.DB) {
+	db.InstanceSet(queryStartKey, time.Now())
+}
+
+func observeQuery(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(queryStartKey)
+		if !ok {
+			return
+		}
+
+		start, ok := value.(time.Time)
+		if !ok {
+			return
+		}
+
+		table := db.Statement.Table
+		if table == "" {
+			// raw SQL doesn't say which table it's about
+			table = "unknown"
+		}
+		queryDuration.WithLabelValues(operation, table).Observe(time.Since(start).Seconds())
+	}
+}
+
+// sizeCollector reports the size of the database when scraped
+type sizeCollector struct {
+	db *gorm.DB
+}
+
+func (c sizeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sizeDesc
+}
+
+func (c sizeCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), SIZE_TIMEOUT)
+	defer cancel()
+
+	var size int64
+	err := c.db.WithContext(ctx).Raw("SELECT pg_database_size(current_database())").Scan(&size).Error
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(sizeDesc, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(sizeDesc, prometheus.GaugeValue, float64(size))
+}
@@ -0,0 +1,72 @@
+package pgsql
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const spanKey = "tracing:span"
+
+var tracer = otel.Tracer("testapp/pkg/pgsql")
+
+// Trace starts a span for every query run through db with a context, as a child of the span
+// in the context. The statement is recorded with its placeholders, never with its values.
+func Trace(db *gorm.DB) error {
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("*").Register("tracing:before_create", startSpan("create")),
+		callbacks.Create().After("*").Register("tracing:after_create", endSpan("create")),
+		callbacks.Query().Before("*").Register("tracing:before_query", startSpan("query")),
+		callbacks.Query().After("*").Register("tracing:after_query", endSpan("query")),
+		callbacks.Update().Before("*").Register("tracing:before_update", startSpan("update")),
+		callbacks.Update().After("*").Register("tracing:after_update", endSpan("update")),
+		callbacks.Delete().Before("*").Register("tracing:before_delete", startSpan("delete")),
+		callbacks.Delete().After("*").Register("tracing:after_delete", endSpan("delete")),
+		callbacks.Row().Before("*").Register("tracing:before_row", startSpan("row")),
+		callbacks.Row().After("*").Register("tracing:after_row", endSpan("row")),
+		callbacks.Raw().Before("*").Register("tracing:before_raw", startSpan("raw")),
+		callbacks.Raw().After("*").Register("tracing:after_raw", endSpan("raw")),
+	)
+}
+
+func startSpan(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		// the table is known once the statement is built, the span is renamed then
+		_, span := tracer.Start(db.Statement.Context, operation, trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperationName(operation)))
+		db.InstanceSet(spanKey, span)
+	}
+}
+
+func endSpan(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(spanKey)
+		if !ok {
+			return
+		}
+
+		span, ok := value.(trace.Span)
+		if !ok {
+			return
+		}
+		defer span.End()
+
+		if table := db.Statement.Table; table != "" {
+			span.SetName(operation + " " + table)
+			span.SetAttributes(semconv.DBCollectionName(table))
+		}
+		span.SetAttributes(semconv.DBQueryText(db.Statement.SQL.String()), attribute.Int64("db.rows_affected", db.RowsAffected))
+
+		// a missing record is an answer, not a failure of the query
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			span.RecordError(db.Error)
+			span.SetStatus(codes.Error, db.Error.Error())
+		}
+	}
+}
@@ -0,0 +1,7 @@
+package storage
+
+type Config struct {
+	Backend string
+	Dir     string
+	MaxSize int64 // largest blob of the pgsql backend, DEFAULT_PGSQL_MAX_SIZE when 0
+}
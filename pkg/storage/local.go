@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	TMP_PREFIX = ".tmp-"
+
+	// times a blob's directory is created, deletes of other blobs can remove it meanwhile
+	PUT_ATTEMPTS = 3
+)
+
+type LocalStore struct {
+	dir string
+}
+
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if !DirectoryExists(dir) {
+		return nil, os.ErrNotExist
+	}
+
+	return &LocalStore{dir: dir}, nil
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, content io.Reader) (BlobInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	// write to a temporary file first, so readers never see a partial blob
+	newFile, err := s.createTemp(filepath.Dir(path))
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	defer os.Remove(newFile.Name())
+	defer newFile.Close()
+
+	if _, err := io.Copy(newFile, content); err != nil {
+		return BlobInfo{}, err
+	}
+
+	if err := newFile.Chmod(0o644); err != nil {
+		return BlobInfo{}, err
+	}
+
+	if err := newFile.Close(); err != nil {
+		return BlobInfo{}, err
+	}
+
+	if err := os.Rename(newFile.Name(), path); err != nil {
+		return BlobInfo{}, err
+	}
+
+	return s.Stat(ctx, key)
+}
+
+// createTemp creates a temporary file in dir. Deleting the last blob of dir removes it,
+// so it's created again when it's removed before the file is.
+func (s *LocalStore) createTemp(dir string) (file *os.File, err error) {
+	for i := 0; i < PUT_ATTEMPTS; i++ {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+
+		file, err = os.CreateTemp(dir, TMP_PREFIX+"*")
+		if !os.IsNotExist(err) {
+			return file, err
+		}
+	}
+
+	return nil, err
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// directories holding other blobs aren't blobs themselves
+	if info, err := file.Stat(); err != nil || info.IsDir() {
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrNotFound
+	}
+
+	return file, nil
+}
+
+func (s *LocalStore) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return BlobInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	return BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// drop directories left empty, it fails harmlessly on non-empty ones
+	for dir := filepath.Dir(path); dir != filepath.Clean(s.dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (s *LocalStore) List(ctx context.Context, prefix string) (blobs []BlobInfo, err error) {
+	err = filepath.WalkDir(s.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return ctx.Err()
+		}
+		if strings.HasPrefix(entry.Name(), TMP_PREFIX) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		blobs = append(blobs, BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return blobs, nil
+}
+
+// path resolves a key inside the store directory, so "../" can't escape it
+func (s *LocalStore) path(key string) (string, error) {
+	clean := filepath.Clean("/" + filepath.FromSlash(key))
+	if clean == string(filepath.Separator) {
+		return "", ErrInvalidKey
+	}
+
+	return filepath.Join(s.dir, clean), nil
+}
+
+func DirectoryExists(path string) bool {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false
+	}
+
+	return info.IsDir()
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type object struct {
+	Key       string    `gorm:"type:text;primaryKey"`
+	Size      int64     `gorm:"not null"`
+	Content   []byte    `gorm:"type:bytea;not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+func (object) TableName() string {
+	return "storage_objects"
+}
+
+func (o object) info() BlobInfo {
+	return BlobInfo{Key: o.Key, Size: o.Size, ModTime: o.UpdatedAt}
+}
+
+type PgSQLStore struct {
+	conn    *gorm.DB
+	maxSize int64
+}
+
+func NewPgSQLStore(conn *gorm.DB, maxSize int64) (*PgSQLStore, error) {
+	if err := conn.AutoMigrate(&object{}); err != nil {
+		return nil, err
+	}
+
+	return &PgSQLStore{conn: conn, maxSize: maxSize}, nil
+}
+
+func (s *PgSQLStore) MaxSize() int64 {
+	return s.maxSize
+}
+
+// bytea can't be written in parts, so the content is buffered before the insert,
+// up to maxSize bytes
+func (s *PgSQLStore) Put(ctx context.Context, key string, content io.Reader) (BlobInfo, error) {
+	contentBytes, err := io.ReadAll(io.LimitReader(content, s.maxSize+1))
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	if int64(len(contentBytes)) > s.maxSize {
+		return BlobInfo{}, ErrTooLarge
+	}
+
+	obj := object{Key: key, Size: int64(len(contentBytes)), Content: contentBytes, UpdatedAt: time.Now()}
+
+	err = s.conn.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&obj).Error
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	return obj.info(), nil
+}
+
+func (s *PgSQLStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	var obj object
+
+	err := s.conn.WithContext(ctx).Where("key = ?", key).First(&obj).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return bytesBlob{bytes.NewReader(obj.Content)}, nil
+}
+
+func (s *PgSQLStore) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	var obj object
+
+	err := s.conn.WithContext(ctx).Omit("content").Where("key = ?", key).First(&obj).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return BlobInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	return obj.info(), nil
+}
+
+func (s *PgSQLStore) Delete(ctx context.Context, key string) error {
+	return s.conn.WithContext(ctx).Where("key = ?", key).Delete(&object{}).Error
+}
+
+func (s *PgSQLStore) List(ctx context.Context, prefix string) ([]BlobInfo, error) {
+	var objs []object
+
+	err := s.conn.WithContext(ctx).Omit("content").Where("key LIKE ?", escapeLike(prefix)+"%").Order("key").Find(&objs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := make([]BlobInfo, 0, len(objs))
+	for _, obj := range objs {
+		blobs = append(blobs, obj.info())
+	}
+
+	return blobs, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// bytesBlob keeps the content seekable, unlike io.NopCloser
+type bytesBlob struct {
+	*bytes.Reader
+}
+
+func (bytesBlob) Close() error {
+	return nil
+}
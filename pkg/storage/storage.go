@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	LOCAL_BACKEND = "local"
+	PGSQL_BACKEND = "pgsql"
+
+	DEFAULT_DIR = "assets/uploads"
+
+	// the pgsql backend holds a blob in memory while it's stored
+	DEFAULT_PGSQL_MAX_SIZE = 64 << 20
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+	ErrTooLarge   = errors.New("blob is too large for the store")
+)
+
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+type BlobStore interface {
+	Put(ctx context.Context, key string, content io.Reader) (BlobInfo, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (BlobInfo, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]BlobInfo, error)
+}
+
+// Limited is implemented by stores that can't hold blobs of any size
+type Limited interface {
+	MaxSize() int64
+}
+
+// MaxSize returns the size of the largest blob the store holds, 0 when there's no limit
+func MaxSize(store BlobStore) int64 {
+	if limited, ok := store.(Limited); ok {
+		return limited.MaxSize()
+	}
+
+	return 0
+}
+
+func NewBlobStore(conf Config, db *gorm.DB) (BlobStore, error) {
+	switch conf.Backend {
+	case "", LOCAL_BACKEND:
+		dir := conf.Dir
+		if dir == "" {
+			dir = DEFAULT_DIR
+		}
+		return NewLocalStore(dir)
+	case PGSQL_BACKEND:
+		maxSize := conf.MaxSize
+		if maxSize <= 0 {
+			maxSize = DEFAULT_PGSQL_MAX_SIZE
+		}
+		return NewPgSQLStore(db, maxSize)
+	}
+
+	return nil, fmt.Errorf("unknown storage backend %q", conf.Backend)
+}
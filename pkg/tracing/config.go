@@ -0,0 +1,20 @@
+package tracing
+
+// exporters spans are sent with, spans are only propagated when there's none
+const (
+	EXPORTER_NONE   = ""
+	EXPORTER_STDOUT = "stdout"
+	EXPORTER_FILE   = "file"
+	EXPORTER_OTLP   = "otlp"
+)
+
+const DEFAULT_SERVICE_NAME = "testapp"
+
+type Config struct {
+	Exporter    string
+	File        string  // spans are appended to it by the file exporter
+	Endpoint    string  // host:port of the OTLP/HTTP collector, the exporter's default when empty
+	Insecure    bool    // sends OTLP over plain HTTP
+	SampleRatio float64 // of the traces started here, all of them when 0
+	ServiceName string
+}
@@ -2,57 +2,216 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
 	"io"
 	"log"
+	"mime"
 	"mime/multipart"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/gorm"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"testapp/internal/handlers"
 	"testapp/internal/models"
+	"testapp/internal/repositories"
 	repPgSQL "testapp/internal/repositories/pgsql"
 	"testapp/internal/services"
 	"testapp/pkg/config"
+	"testapp/pkg/exif"
+	"testapp/pkg/imaging"
 	pkgHTTP "testapp/pkg/http"
+	"testapp/pkg/metrics"
 	pkgPgSQL "testapp/pkg/pgsql"
+	"testapp/pkg/storage"
+	"testapp/pkg/tracing"
 )
 
+// client authenticates with an API key created for the tests
 var client *http.Client 
 
+// otherClient is another user, it sees only what the tests share with it
+var otherClient *http.Client
+
+// adminClient is an admin, it sees what nobody owns, like files stored before uploads were recorded
+var adminClient *http.Client
+
+var authConf pkgHTTP.AuthConfig
+
+// metadataPolicy is what the server removes from uploaded images
+var metadataPolicy string
+
+// cacheDir is where the server keeps transformed images
+var cacheDir string
+
+// spans are what the server traced
+var spans = tracetest.NewInMemoryExporter()
+
+// the server's handlers and authentication, for tests running servers of their own
+var (
+	testHandlers   []pkgHTTP.Handler
+	testAuth       pkgHTTP.Authenticator
+	testDB         *gorm.DB
+	testStore      storage.BlobStore
+	testImageRep   repositories.ImageRepository
+	testImages     *services.ImageService
+	testTransforms *services.TransformService
+)
+
+// authTransport sends the API key with every request
+type authTransport struct {
+	base http.RoundTripper
+	key  string
+}
+
+func (t authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(pkgHTTP.API_KEY_HEADER, t.key)
+
+	return t.base.RoundTrip(req)
+}
+
+const FILENAME = "diagram.png"
+
 func init() {
 	if err := os.Chdir(filepath.Join("..")); err != nil {
 		log.Fatal(err)
 	}
 
-	client = &http.Client{
-		Transport: &http.Transport{MaxIdleConnsPerHost: 100},
-	}
-
 	config, err := config.LoadConfig("config", "yaml", filepath.Join(".", "configs"))
 	if err != nil {
 		log.Fatalf("Error loading a config: %v", err)
 	}
+	metadataPolicy = config.Imaging.Metadata
 
 	// Connecting to database
 	db, err := pkgPgSQL.NewPgSQLConnection(config.PgSQL)
 	if err != nil {
 		log.Fatalf("Error connecting to pgsql db: %v", err)
 	}
+	if err := pkgPgSQL.Instrument(db, config.PgSQL.DBName); err != nil {
+		log.Fatalf("Error instrumenting the database: %v", err)
+	}
+	// spans are kept in memory, only the propagators are set up
+	if _, err := tracing.Setup(context.Background(), tracing.Config{}); err != nil {
+		log.Fatalf("Error setting up tracing: %v", err)
+	}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans)))
+	if err := pkgPgSQL.Trace(db); err != nil {
+		log.Fatalf("Error tracing the database: %v", err)
+	}
+
+	store, err := storage.NewBlobStore(config.Storage, db)
+	if err != nil {
+		log.Fatalf("Error creating a blob store: %v", err)
+	}
 
 	imageRep := repPgSQL.NewImageRepository(db)
-	imageServ := services.NewImageService(imageRep)
-	imageHandler := handlers.NewImageHandler(imageServ)
+	fileRep := repPgSQL.NewFileRepository(db)
+	usageRep := repPgSQL.NewUsageRepository(db)
+	imageServ, err := services.NewImageService(imageRep, fileRep, usageRep, store, config.Imaging)
+	if err != nil {
+		log.Fatalf("Error creating the image service: %v", err)
+	}
+	// Setting up a cache for transformed images
+	cacheDir = config.Imaging.CacheDir
+	if cacheDir == "" {
+		cacheDir = imaging.DEFAULT_CACHE_DIR
+	}
+
+	cache, err := storage.NewLocalStore(cacheDir)
+	if err != nil {
+		log.Fatalf("Error creating an image cache: %v", err)
+	}
+
+	transformServ := services.NewTransformService(imageServ, cache)
+	renditionServ := services.NewRenditionService(imageRep, imageServ, config.Imaging.Renditions, config.Imaging.Workers)
+	imageServ.OnSave(renditionServ.Enqueue)
+	imageServ.OnPurge(transformServ.Forget)
+	imageHandler := handlers.NewImageHandler(imageServ, transformServ, renditionServ, config.HTTP)
 	formatHandler := handlers.NewFormatHandler()
 
-	srv := pkgHTTP.NewServer(config.HTTP, imageHandler, formatHandler)
+	linkRep := repPgSQL.NewLinkRepository(db)
+	linkServ, err := services.NewLinkService(linkRep, imageServ, config.Imaging)
+	if err != nil {
+		log.Fatalf("Error creating the link service: %v", err)
+	}
+	linkHandler := handlers.NewLinkHandler(linkServ, imageHandler)
+	usageHandler := handlers.NewUsageHandler(imageServ)
+	prometheus.MustRegister(imageServ.Collector())
+	// scraped without credentials, so the tests see what a scraper does
+	metricsHandler := handlers.NewMetricsHandler(metrics.Config{Public: true})
+
+	uploadRep := repPgSQL.NewUploadRepository(db)
+	uploadServ := services.NewUploadService(uploadRep, store, imageServ)
+	tusHandler := handlers.NewTusHandler(uploadServ)
+
+	go renditionServ.Run(context.Background(), time.Minute)
+
+	auth, err := pkgHTTP.NewAuth(config.HTTP.Auth, db)
+	if err != nil {
+		log.Fatalf("Error setting up authentication: %v", err)
+	}
+	authConf = config.HTTP.Auth
+
+	expiresAt := time.Now().Add(time.Hour)
+	key, err := auth.Keys().Create(context.Background(), "tests", nil, &expiresAt)
+	if err != nil {
+		log.Fatalf("Error creating an API key: %v", err)
+	}
+	client = &http.Client{
+		Transport: authTransport{base: &http.Transport{MaxIdleConnsPerHost: 100}, key: key},
+	}
+
+	otherKey, err := auth.Keys().Create(context.Background(), "other", nil, &expiresAt)
+	if err != nil {
+		log.Fatalf("Error creating an API key: %v", err)
+	}
+	otherClient = &http.Client{
+		Transport: authTransport{base: http.DefaultTransport, key: otherKey},
+	}
+
+	adminKey, err := auth.Keys().Create(context.Background(), "admin", []string{pkgHTTP.ROLE_ADMIN}, &expiresAt)
+	if err != nil {
+		log.Fatalf("Error creating an API key: %v", err)
+	}
+	adminClient = &http.Client{
+		Transport: authTransport{base: http.DefaultTransport, key: adminKey},
+	}
+
+	testHandlers = []pkgHTTP.Handler{imageHandler, formatHandler, tusHandler, linkHandler, usageHandler, metricsHandler}
+	testAuth = auth
+	testDB, testStore = db, store
+	testImageRep, testImages, testTransforms = imageRep, imageServ, transformServ
+	srv := pkgHTTP.NewServer(config.HTTP, auth, testHandlers...)
 
 	go func() {
 		if err := srv.ListenAndServe(); err != nil {
@@ -70,7 +229,8 @@ func TestServerGetEndpoint(t *testing.T) {
 		{handlers.JSON_PATH, http.StatusOK},
 		{handlers.XML_PATH, http.StatusOK},
 		{"/fooo", http.StatusNotFound},
-		{handlers.DOWNLOAD_PATH, http.StatusOK},
+		// the file was stored before uploads were recorded, so nobody owns it
+		{"/download/" + FILENAME, http.StatusNotFound},
 	}
 
 	for _, test := range tests {
@@ -84,6 +244,59 @@ func TestServerGetEndpoint(t *testing.T) {
 	}
 }
 
+func TestAuthentication(t *testing.T) {
+	anonymous := &http.Client{}
+	u := "http://0.0.0.0:8080" + handlers.IMAGES_PATH
+
+	resp, err := anonymous.Get(u)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Images are listed without credentials")
+	assert.NotEmpty(t, resp.Header.Get("WWW-Authenticate"))
+
+	resp, err = anonymous.Get("http://0.0.0.0:8080" + handlers.FOO_PATH)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "A public route needs credentials")
+
+	get := func(header, value string) int {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		require.NoError(t, err)
+		req.Header.Set(header, value)
+
+		resp, err := anonymous.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get(pkgHTTP.API_KEY_HEADER, pkgHTTP.API_KEY_PREFIX+"0000_wrong"))
+	assert.Equal(t, http.StatusUnauthorized, get("Authorization", "Bearer not.a.token"))
+
+	if authConf.HMACSecret == "" {
+		t.Skip("HS256 tokens aren't configured")
+	}
+
+	sign := func(claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(authConf.HMACSecret))
+		require.NoError(t, err)
+		return token
+	}
+
+	claims := jwt.MapClaims{"sub": "token-tests", "exp": time.Now().Add(time.Minute).Unix()}
+	if authConf.Issuer != "" {
+		claims["iss"] = authConf.Issuer
+	}
+	if authConf.Audience != "" {
+		claims["aud"] = authConf.Audience
+	}
+	assert.Equal(t, http.StatusOK, get("Authorization", "Bearer "+sign(claims)))
+
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	assert.Equal(t, http.StatusUnauthorized, get("Authorization", "Bearer "+sign(claims)), "An expired token is accepted")
+}
+
 func TestServerJSON(t *testing.T) {
 	resp, err := client.Get(fmt.Sprintf("http://0.0.0.0:8080%s", handlers.JSON_PATH))
 	require.NoError(t, err, "Client failed to GET the http://0.0.0.0:8080%s", handlers.JSON_PATH)
@@ -99,58 +312,264 @@ func TestServerJSON(t *testing.T) {
 	assert.Equal(t, want, len(books), "Books len = %d, want %d", len(books), want)
 }
 
+func TestMiddlewares(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "http://0.0.0.0:8080"+handlers.JSON_PATH, nil)
+	require.NoError(t, err)
+	req.Header.Set(pkgHTTP.REQUEST_ID_HEADER, "middleware-test")
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "middleware-test", resp.Header.Get(pkgHTTP.REQUEST_ID_HEADER), "The request id isn't passed on")
+
+	resp, err = client.Get("http://0.0.0.0:8080/missing")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.NotEmpty(t, resp.Header.Get(pkgHTTP.REQUEST_ID_HEADER), "Unknown routes don't get a request id")
+
+	// JSON bodies are limited much tighter than uploads
+	body := fmt.Sprintf(`{"ids": [], "padding": %q}`, strings.Repeat("x", handlers.MaxJSONSize))
+	resp = imageRequest(t, http.MethodDelete, handlers.IMAGES_PATH, strings.NewReader(body))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+func TestProblemResponses(t *testing.T) {
+	get := func(accept string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, "http://0.0.0.0:8080/images?sort=bogus", nil)
+		require.NoError(t, err)
+		req.Header.Set(pkgHTTP.REQUEST_ID_HEADER, "problem-test")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := get(pkgHTTP.PROBLEM_CONTENT_TYPE)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, pkgHTTP.PROBLEM_CONTENT_TYPE, resp.Header.Get("Content-Type"))
+
+	var problem pkgHTTP.Problem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, pkgHTTP.CODE_INVALID_INPUT, problem.Code)
+	assert.Equal(t, http.StatusBadRequest, problem.Status)
+	assert.Equal(t, "problem-test", problem.RequestID)
+	require.Len(t, problem.Errors, 1)
+	assert.Equal(t, "sort", problem.Errors[0].Field)
+
+	// clients not asking for JSON get the plain text they always got
+	resp = get("")
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain"))
+	assert.True(t, strings.HasPrefix(string(body), http.StatusText(http.StatusBadRequest)+"\n"))
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	const addr = "localhost:8081"
+	conf := pkgHTTP.Config{Port: 8081, ShutdownTimeout: 10 * time.Second}
+	lifecycle := pkgHTTP.NewLifecycle(pkgHTTP.NewServer(conf, testAuth, testHandlers...), conf)
+
+	workerStopped := make(chan struct{})
+	lifecycle.Go("test", func(ctx context.Context) {
+		<-ctx.Done()
+		close(workerStopped)
+	})
+	var closed []string
+	lifecycle.OnStop("first", func() error {
+		closed = append(closed, "first")
+		return nil
+	})
+	lifecycle.OnStop("second", func() error {
+		closed = append(closed, "second")
+		return nil
+	})
+
+	ctx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- lifecycle.Run(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond, "The server doesn't start")
+
+	// the upload is sent in two halves, the server shuts down in between
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	req, err := http.NewRequest(http.MethodPost, "http://"+addr+handlers.SAVE_DB_PATH, body)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	inFlight := func() float64 { return metricValue(t, "testapp_http_requests_in_flight", nil) }
+	idle := inFlight()
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := client.Do(req)
+		assert.NoError(t, err, "The upload is dropped during shutdown")
+		responses <- resp
+	}()
+
+	part, err := form.CreateFormFile("myfiles", "shutdownTest.png")
+	require.NoError(t, err)
+	content := pngContent("this image is uploaded while the server shuts down")
+	_, err = part.Write(content[:len(content)/2])
+	require.NoError(t, err)
+
+	// the handler is serving the upload when the server is told to stop
+	require.Eventually(t, func() bool { return inFlight() > idle }, 5*time.Second, 10*time.Millisecond,
+		"The upload doesn't reach the handler")
+	shutdown()
+
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond, "New connections are accepted during shutdown")
+
+	_, err = part.Write(content[len(content)/2:])
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+	require.NoError(t, writer.Close())
+
+	resp := <-responses
+	require.NotNil(t, resp)
+	defer resp.Body.Close()
+	var report batchReport
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "The upload in flight isn't completed: %+v", report)
+	assert.Equal(t, 1, report.Saved)
+
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(conf.ShutdownTimeout):
+		t.Fatal("The server doesn't stop")
+	}
+	assert.True(t, isClosed(workerStopped), "The worker isn't stopped")
+	assert.Equal(t, []string{"second", "first"}, closed, "Resources aren't closed in the reverse order")
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
 func TestDownloadFile(t *testing.T) {
-	resp, err := client.Get(fmt.Sprintf("http://0.0.0.0:8080%s", handlers.DOWNLOAD_PATH))
-	require.NoError(t, err, "Client failed to GET the http://0.0.0.0:8080%s", handlers.DOWNLOAD_PATH)
+	resp, err := adminClient.Get("http://0.0.0.0:8080/download/" + FILENAME)
+	require.NoError(t, err, "Client failed to GET the http://0.0.0.0:8080/download/%s", FILENAME)
+	defer resp.Body.Close()
 
 	//check content type
 	ct := resp.Header.Get("Content-Type")
 	want := "image/png"
 	require.Equal(t, want, ct, "Content-Type is %s, want = %s", ct, want)
 
-	//check content disposition
-	cd := resp.Header.Get("Content-Disposition")
-	cdSlice := strings.Split(cd, ";")
-	cd = cdSlice[0]
-	want = "attachment"
-	require.Equal(t, want, cd, "Content-Disposition is %s, want = %s", cd, want)
-
-	//check filename
-	require.Greater(t, len(cdSlice), 1, "There is no filename parameter in Content-Disposition")
-	for _, param := range cdSlice[1:] {
-		if strings.HasPrefix(strings.TrimSpace(param), "filename=") {
-			filename := strings.TrimSpace(param)[len("filename="):]
-			require.Equal(t, handlers.FILENAME, filename, "Filename is %s, want = %s", filename, handlers.FILENAME)
-		}
-	}
+	//check content disposition and filename
+	disposition, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	require.NoError(t, err, "Error parsing the Content-Disposition")
+	require.Equal(t, "attachment", disposition, "Content-Disposition is %s, want = attachment", disposition)
+	require.Equal(t, FILENAME, params["filename"], "Filename is %s, want = %s", params["filename"], FILENAME)
 	
 	//check file
 	body, err := io.ReadAll(resp.Body)
 	require.NoError(t, err, "Error reading the resp.Body")
 
 
-	fileBytes, err := os.ReadFile(filepath.Join(".", "assets", "tmp", handlers.FILENAME))
+	fileBytes, err := os.ReadFile(filepath.Join(".", "assets", "uploads", FILENAME))
 	require.NoError(t, err, "Error reading the resp.Body")
 
 	assert.True(t, bytes.Equal(body, fileBytes), "Body content is not identical to original file")
 }
 
-func sendFile(t *testing.T, filename string, content []byte) (resp *http.Response) {
-	var body = &bytes.Buffer{}
-	
-	writer := multipart.NewWriter(body)
-	fileWriter, err := writer.CreateFormFile("myfiles", filename)
+func TestDownloadFileAccess(t *testing.T) {
+	filename := fmt.Sprintf("access %d.png", time.Now().UnixNano())
+	testUpload(t, filename, pngContent("this file is downloaded by its owner only"), "Wrong resp.statusCode on %s endpoint, statusCode is %d, want %d", http.StatusOK)
+
+	for _, test := range []struct {
+		name   string
+		client *http.Client
+		want   int
+	}{
+		{"owner", client, http.StatusOK},
+		{"other user", otherClient, http.StatusNotFound},
+		{"admin", adminClient, http.StatusOK},
+	} {
+		resp, err := test.client.Get("http://0.0.0.0:8080/download/" + url.PathEscape(filename))
+		require.NoError(t, err, "Error downloading the file")
+		resp.Body.Close()
+		assert.Equal(t, test.want, resp.StatusCode, "Wrong status for the %s", test.name)
+	}
+
+	resp, err := client.Get("http://0.0.0.0:8080/download/" + FILENAME)
+	require.NoError(t, err, "Error downloading the file")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "A file nobody owns is served to a user who isn't an admin")
+}
+
+func TestDownloadImage(t *testing.T) {
+	filename := "диаграмма 1.png"
+	content := pngContent("this image is downloaded by id")
+	id := saveImage(t, filename, content)
+
+	resp := imageRequest(t, http.MethodGet, "/download/"+id+"?disposition=inline", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "nosniff", resp.Header.Get("X-Content-Type-Options"))
+
+	disposition, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	require.NoError(t, err, "Error parsing the Content-Disposition")
+	assert.Equal(t, "inline", disposition)
+	// mime decodes filename* into filename
+	assert.Equal(t, filename, params["filename"])
+
+	resp = imageRequest(t, http.MethodGet, "/download/"+id+"?disposition=evil", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "An unknown disposition is accepted")
+
+	resp = imageRequest(t, http.MethodGet, "/download/..%2Fconfigs%2Fconfig.yaml", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "A path outside of the files is served")
+
+	resp = imageRequest(t, http.MethodGet, "/download/missing.png", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// sendFile streams the multipart body, so large files are never held in memory
+func sendFile(t *testing.T, filename string, content io.Reader, size int64) (resp *http.Response) {
+	var head = &bytes.Buffer{}
+
+	writer := multipart.NewWriter(head)
+	_, err := writer.CreateFormFile("myfiles", filename)
 	require.NoError(t, err, "Error creating a form")
 
-	_, err = io.Copy(fileWriter, bytes.NewReader(content))
-	require.NoError(t, err, "Error copying content to a writer")
+	prefix := bytes.Clone(head.Bytes())
+	head.Reset()
 
 	require.NoError(t, writer.Close(), "Error closing multipart writer")
+	suffix := head.Bytes()
+
+	body := io.MultiReader(bytes.NewReader(prefix), content, bytes.NewReader(suffix))
 
 	u := fmt.Sprintf("http://0.0.0.0:8080%s", handlers.UPLOAD_PATH)
 	req, err := http.NewRequest(http.MethodPost, u, body)
 	require.NoError(t, err, "Error creating a new POST request to %s", u)
 
+	req.ContentLength = int64(len(prefix)) + size + int64(len(suffix))
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 
 	resp, err = client.Do(req)
@@ -162,28 +581,93 @@ func sendFile(t *testing.T, filename string, content []byte) (resp *http.Respons
 }
 
 func testUpload(t *testing.T, filename string, content []byte, errMsgTemplate string, want int) {
-	resp := sendFile(t, filename, content)
+	testUploadReader(t, filename, bytes.NewReader(content), int64(len(content)), errMsgTemplate, want)
+}
+
+func testUploadReader(t *testing.T, filename string, content io.Reader, size int64, errMsgTemplate string, want int) {
+	resp := sendFile(t, filename, content, size)
 	got := resp.StatusCode
 	assert.Equal(t, want, got, errMsgTemplate, handlers.UPLOAD_PATH, got, want)
 }
 
 func TestUploadFile(t *testing.T) {
-	fp := filepath.Join(".", "assets", "uploads", handlers.FILENAME)
+	fp := filepath.Join(".", "assets", "uploads", FILENAME)
 	content, err := os.ReadFile(fp)
 	require.NoError(t, err, "Error opening the file %s", fp)
 
-	testUpload(t, handlers.FILENAME, content, "Wrong resp.statusCode on %s endpoint, statusCode is %d, want %d", http.StatusOK)
+	testUpload(t, FILENAME, content, "Wrong resp.statusCode on %s endpoint, statusCode is %d, want %d", http.StatusOK)
+}
+
+// TestUploadCollision expects the suffix collision policy of the example config
+func TestUploadCollision(t *testing.T) {
+	filename := fmt.Sprintf("collision %d.png", time.Now().UnixNano())
+	content := pngContent("this file is uploaded twice")
+
+	testUpload(t, filename, content, "Wrong resp.statusCode on %s endpoint, statusCode is %d, want %d", http.StatusOK)
+	testUpload(t, filename, content, "Wrong resp.statusCode on %s endpoint, statusCode is %d, want %d", http.StatusOK)
+
+	for _, name := range []string{filename, strings.TrimSuffix(filename, ".png") + " (1).png"} {
+		resp := imageRequest(t, http.MethodGet, "/download/"+url.PathEscape(name), nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode, "%s isn't stored", name)
+
+		_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+		require.NoError(t, err, "Error parsing the Content-Disposition")
+		assert.Equal(t, filename, params["filename"], "The original filename isn't kept")
+	}
+}
+
+func TestImageServiceConfig(t *testing.T) {
+	// a policy uploads can't follow is refused when the server starts, not on every upload
+	_, err := services.NewImageService(nil, nil, nil, nil, imaging.Config{Collision: "rename"})
+	assert.ErrorIs(t, err, services.ErrInvalidCollision)
+
+	_, err = services.NewImageService(nil, nil, nil, nil, imaging.Config{Metadata: "strip_location"})
+	assert.ErrorIs(t, err, exif.ErrInvalidPolicy)
+
+	_, err = services.NewImageService(nil, nil, nil, nil, imaging.Config{Collision: services.COLLISION_UUID, Metadata: exif.STRIP_ALL})
+	assert.NoError(t, err)
 }
 
 func TestMaxUploadSize(t *testing.T) {
-	content := make([]byte, handlers.MaxUploadSize + 1)
+	size := int64(handlers.MaxUploadSize + 1)
+	content := io.LimitReader(zeroReader{}, size)
 	filename := "maxSizeTest.txt"
-	testUpload(t, filename, content, "Sending oversized file on %s endpoint, statusCode is %d, want %d", http.StatusRequestEntityTooLarge)
+	testUploadReader(t, filename, content, size, "Sending oversized file on %s endpoint, statusCode is %d, want %d", http.StatusRequestEntityTooLarge)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// pngContent is a 1x1 PNG carrying text in a private chunk, which is kept whatever the metadata policy
+func pngContent(text string) []byte {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		panic(err)
+	}
+
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(text)))
+	chunk = append(chunk, "tsTx"+text...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	// after the signature and IHDR
+	content := encoded.Bytes()
+	header := 8 + 8 + 13 + 4
+	return append(append(append([]byte{}, content[:header]...), chunk...), content[header:]...)
+}
+
+func TestUploadUnsupportedType(t *testing.T) {
+	filename := "unsupportedTypeTest.png"
+	content := []byte("this is not an image")
+	testUpload(t, filename, content, "Sending a text file on %s endpoint, statusCode is %d, want %d", http.StatusUnsupportedMediaType)
 }
 
 func TestSaveFile(t *testing.T) {
-	filename := "saveFileTest.txt"
-	content := []byte("this is test file")
+	filename := "saveFileTest.png"
+	content := pngContent("this is test file")
 	testUpload(t, filename, content, "Error saving the file on %s endpoint, statusCode is %d, want %d", http.StatusOK)
 	
 	savedFilePath := filepath.Join(".", "assets", "uploads", filename)
@@ -194,6 +678,820 @@ func TestSaveFile(t *testing.T) {
 	assert.Equal(t, savedContent, content, "Content of a saved file %s is not identical to content from client's file", filename)
 }
 
+func tusRequest(t *testing.T, method, u string, body io.Reader, headers map[string]string) *http.Response {
+	return tusRequestAs(t, client, method, u, body, headers)
+}
+
+func tusRequestAs(t *testing.T, client *http.Client, method, u string, body io.Reader, headers map[string]string) *http.Response {
+	req, err := http.NewRequest(method, u, body)
+	require.NoError(t, err, "Error creating a new %s request to %s", method, u)
+
+	req.Header.Set("Tus-Resumable", handlers.TUS_VERSION)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	require.NoError(t, err, "Error sending the %s request to %s", method, u)
+	defer resp.Body.Close()
+
+	return resp
+}
+
+func TestTusUpload(t *testing.T) {
+	content := pngContent("this is a file uploaded in two chunks")
+	half := len(content) / 2
+
+	resp := tusRequest(t, http.MethodPost, fmt.Sprintf("http://0.0.0.0:8080%s", handlers.TUS_PATH), nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(content)),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("tus.png")),
+	})
+	require.Equal(t, http.StatusCreated, resp.StatusCode, "Creating an upload failed")
+
+	u := "http://0.0.0.0:8080" + resp.Header.Get("Location")
+	patch := func(offset int, chunk []byte) *http.Response {
+		return tusRequest(t, http.MethodPatch, u, bytes.NewReader(chunk), map[string]string{
+			"Content-Type":  handlers.TUS_CONTENT_TYPE,
+			"Upload-Offset": strconv.Itoa(offset),
+		})
+	}
+
+	resp = patch(0, content[:half])
+	require.Equal(t, http.StatusNoContent, resp.StatusCode, "Sending the first chunk failed")
+
+	resp = tusRequest(t, http.MethodHead, u, nil, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, strconv.Itoa(half), resp.Header.Get("Upload-Offset"))
+
+	// only the creator of the upload sees it
+	for _, method := range []string{http.MethodHead, http.MethodPatch, http.MethodDelete} {
+		var body io.Reader
+		if method == http.MethodPatch {
+			body = bytes.NewReader(content[half:])
+		}
+		resp = tusRequestAs(t, otherClient, method, u, body, map[string]string{
+			"Content-Type":  handlers.TUS_CONTENT_TYPE,
+			"Upload-Offset": strconv.Itoa(half),
+		})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "%s of an upload of another user isn't answered as not found", method)
+	}
+
+	resp = patch(0, content[:half])
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "Resending a chunk at a stale offset must conflict")
+
+	resp = patch(half, content[half:])
+	require.Equal(t, http.StatusNoContent, resp.StatusCode, "Sending the last chunk failed")
+	assert.Equal(t, strconv.Itoa(len(content)), resp.Header.Get("Upload-Offset"))
+
+	imageID := resp.Header.Get("Image-Id")
+	require.NotEmpty(t, imageID, "Completed upload has no image")
+
+	resp, err := client.Get(fmt.Sprintf("http://0.0.0.0:8080/show/%s", imageID))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err, "Error reading the resp.Body")
+	assert.Equal(t, content, body, "Assembled image differs from the uploaded content")
+}
+
+func TestListImages(t *testing.T) {
+	list := func(path string) ([]models.Image, *http.Response) {
+		resp, err := client.Get("http://0.0.0.0:8080" + path)
+		require.NoError(t, err, "Client failed to GET %s", path)
+		defer resp.Body.Close()
+
+		var images []models.Image
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&images), "Error decoding the images json")
+		}
+
+		return images, resp
+	}
+
+	first, resp := list(handlers.IMAGES_PATH + "?limit=1")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, first, 1, "Images are saved by the previous tests")
+
+	cursor := resp.Header.Get("X-Next-Cursor")
+	if cursor != "" {
+		assert.Contains(t, resp.Header.Get("Link"), `rel="next"`)
+
+		second, resp := list(handlers.IMAGES_PATH + "?limit=1&cursor=" + cursor)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Len(t, second, 1)
+		assert.NotEqual(t, first[0].ID, second[0].ID, "The next page repeats an image")
+		assert.False(t, second[0].CreatedAt.After(first[0].CreatedAt), "Images aren't sorted by upload time")
+
+		_, resp = list(handlers.IMAGES_PATH + "?sort=size&cursor=" + cursor)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "A cursor must not be reused with another sort")
+	}
+
+	_, resp = list(handlers.IMAGES_PATH + "?sort=name")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+// saveImage saves the content on the db endpoint and returns the id of the image
+// batchRequest posts the files to SAVE_DB_PATH in the given mode and decodes the report
+func batchRequest(t *testing.T, mode string, files map[string][]byte) (*http.Response, batchReport) {
+	var body = &bytes.Buffer{}
+
+	writer := multipart.NewWriter(body)
+	for filename, content := range files {
+		part, err := writer.CreateFormFile("myfiles", filename)
+		require.NoError(t, err, "Error creating a form")
+		_, err = part.Write(content)
+		require.NoError(t, err, "Error writing the form")
+	}
+	require.NoError(t, writer.Close(), "Error closing multipart writer")
+
+	u := fmt.Sprintf("http://0.0.0.0:8080%s?mode=%s", handlers.SAVE_DB_PATH, mode)
+	resp, err := client.Post(u, writer.FormDataContentType(), body)
+	require.NoError(t, err, "Error sending the POST request to %s", u)
+	defer resp.Body.Close()
+
+	var report batchReport
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report), "Error decoding the batch report")
+
+	return resp, report
+}
+
+type batchReport struct {
+	Saved int `json:"saved"`
+	Files []struct {
+		Filename    string `json:"filename"`
+		ID          string `json:"id"`
+		ContentType string `json:"content_type"`
+		Error       string `json:"error"`
+	} `json:"files"`
+}
+
+func saveImage(t *testing.T, filename string, content []byte) string {
+	resp, report := batchRequest(t, services.BATCH_ATOMIC, map[string][]byte{filename: content})
+	require.Equal(t, http.StatusOK, resp.StatusCode, "Error saving the file: %+v", report)
+	require.Len(t, report.Files, 1)
+
+	return report.Files[0].ID
+}
+
+func TestSaveBatch(t *testing.T) {
+	files := map[string][]byte{
+		"batchTest.png": pngContent("this image is saved in a batch"),
+		"batchTest.txt": []byte("this file isn't an image"),
+	}
+
+	resp, report := batchRequest(t, services.BATCH_ATOMIC, files)
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode, "An atomic batch with a bad file isn't rejected")
+	assert.Zero(t, report.Saved, "Images of a rejected atomic batch are saved")
+	for _, file := range report.Files {
+		assert.Empty(t, file.ID, "%s of a rejected atomic batch is saved", file.Filename)
+		assert.NotEmpty(t, file.Error, "%s of a rejected atomic batch has no error", file.Filename)
+	}
+
+	resp, report = batchRequest(t, services.BATCH_BEST_EFFORT, files)
+	require.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Equal(t, 1, report.Saved)
+	require.Len(t, report.Files, 2)
+	for _, file := range report.Files {
+		if file.Filename == "batchTest.png" {
+			assert.NotEmpty(t, file.ID, "The image isn't saved")
+			assert.Equal(t, "image/png", file.ContentType)
+		} else {
+			assert.Empty(t, file.ID, "The text file is saved")
+			assert.Equal(t, "text/plain", file.ContentType)
+			assert.NotEmpty(t, file.Error)
+		}
+	}
+}
+
+func imageRequest(t *testing.T, method, path string, body io.Reader) *http.Response {
+	req, err := http.NewRequest(method, "http://0.0.0.0:8080"+path, body)
+	require.NoError(t, err, "Error creating a new %s request to %s", method, path)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err, "Error sending the %s request to %s", method, path)
+	defer resp.Body.Close()
+
+	return resp
+}
+
+func TestMissingImage(t *testing.T) {
+	id := uuid.NewString()
+	for _, path := range []string{"/show/" + id, "/images/" + id + "/meta", "/images/" + id + "/renditions", "/download/" + id} {
+		resp := imageRequest(t, http.MethodGet, path, nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "A missing image isn't reported as such on %s", path)
+	}
+}
+
+// jpegContent is a 3x2 JPEG with EXIF naming the camera's make, "Cam"
+func jpegContent() []byte {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 3, 2)), nil); err != nil {
+		panic(err)
+	}
+
+	// little-endian TIFF with the make as the only entry, stored in the entry itself
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0, 1, 0, 0x0f, 0x01, 2, 0, 4, 0, 0, 0}
+	tiff = append(tiff, "Cam\x00"...)
+	tiff = append(tiff, 0, 0, 0, 0)
+
+	segment := binary.BigEndian.AppendUint16([]byte{0xff, 0xe1}, uint16(2+6+len(tiff)))
+	segment = append(append(segment, "Exif\x00\x00"...), tiff...)
+
+	// after SOI
+	content := encoded.Bytes()
+	return append(append(append([]byte{}, content[:2]...), segment...), content[2:]...)
+}
+
+func TestImageMeta(t *testing.T) {
+	id := saveImage(t, "metaTest.jpg", jpegContent())
+
+	resp := imageRequest(t, http.MethodGet, "/download/"+id, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	stored, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	digest := sha256.Sum256(stored)
+
+	req, err := http.NewRequest(http.MethodGet, "http://0.0.0.0:8080/images/"+id+"/meta", nil)
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var meta models.Image
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&meta))
+	assert.Equal(t, id, meta.ID.String())
+	assert.Equal(t, "image/jpeg", meta.ContentType)
+	assert.Equal(t, hex.EncodeToString(digest[:]), meta.Digest, "The digest isn't of the stored content")
+	assert.Equal(t, int64(len(stored)), meta.Size)
+	assert.Equal(t, 3, meta.Width)
+	assert.Equal(t, 2, meta.Height)
+	assert.Equal(t, "gray", meta.ColorModel)
+	assert.Equal(t, 1, meta.Frames)
+	assert.Equal(t, models.VISIBILITY_PRIVATE, meta.Visibility)
+
+	if metadataPolicy == exif.STRIP_ALL {
+		assert.Nil(t, meta.Exif, "EXIF of an image stripped of it is shown")
+	} else {
+		require.NotNil(t, meta.Exif, "EXIF isn't shown")
+		assert.Equal(t, "Cam", meta.Exif.Make)
+	}
+
+	resp, err = otherClient.Get("http://0.0.0.0:8080/images/" + id + "/meta")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Metadata of a private image is shown to others")
+}
+
+func TestDeleteRestoreImage(t *testing.T) {
+	id := saveImage(t, "deleteTest.png", pngContent("this image is deleted and restored"))
+
+	resp := imageRequest(t, http.MethodDelete, "/images/"+id, nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode, "Deleting the image failed")
+
+	resp = imageRequest(t, http.MethodGet, "/images/"+id+"/meta", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "A deleted image is still shown")
+
+	resp = imageRequest(t, http.MethodDelete, "/images/"+id, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "An image in the trash can't be deleted again")
+
+	resp = imageRequest(t, http.MethodPost, "/images/"+id+"/restore", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "Restoring the image failed")
+
+	resp = imageRequest(t, http.MethodGet, "/images/"+id+"/meta", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "A restored image isn't shown")
+
+	resp = imageRequest(t, http.MethodPost, "/images/"+id+"/restore", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "An image not in the trash can't be restored")
+}
+
+func TestContentDeduplication(t *testing.T) {
+	ctx := context.Background()
+	content := imageContent(5, 5)
+	first, second := saveImage(t, "dedupTest.png", content), saveImage(t, "dedupCopyTest.png", content)
+
+	stored := func(id string) models.Image {
+		image, err := testImageRep.Get(ctx, uuid.MustParse(id))
+		require.NoError(t, err)
+		return image
+	}
+	firstImage, secondImage := stored(first), stored(second)
+	require.NotEmpty(t, firstImage.Digest)
+	assert.Equal(t, firstImage.Digest, secondImage.Digest)
+	assert.Equal(t, firstImage.StorageKey, secondImage.StorageKey, "Identical content is stored twice")
+
+	blobs := func() []models.Blob {
+		var blobs []models.Blob
+		require.NoError(t, testDB.Where("digest = ?", firstImage.Digest).Find(&blobs).Error)
+		return blobs
+	}
+	found := blobs()
+	require.Len(t, found, 1)
+	assert.Equal(t, int64(2), found[0].RefCount)
+	assert.Equal(t, firstImage.StorageKey, found[0].StorageKey)
+
+	purge := func(id string) {
+		resp := imageRequest(t, http.MethodDelete, "/images/"+id, nil)
+		require.Equal(t, http.StatusNoContent, resp.StatusCode, "Deleting the image failed")
+		require.NoError(t, testImages.Purge(ctx, []uuid.UUID{uuid.MustParse(id)}, time.Now()))
+	}
+
+	purge(first)
+	found = blobs()
+	require.Len(t, found, 1, "The content is forgotten while another image uses it")
+	assert.Equal(t, int64(1), found[0].RefCount)
+
+	resp, err := client.Get("http://0.0.0.0:8080/download/" + second)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "The content is deleted while another image uses it")
+	downloaded, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, content, downloaded)
+
+	purge(second)
+	assert.Empty(t, blobs(), "The content of the last image using it is kept in db")
+	_, err = testStore.Get(ctx, firstImage.StorageKey)
+	assert.ErrorIs(t, err, storage.ErrNotFound, "The content of the last image using it is kept in the store")
+}
+
+func TestImageAccess(t *testing.T) {
+	id := saveImage(t, "accessTest.png", pngContent("this image is shared with another user"))
+	other := func(method, path string, body io.Reader) *http.Response {
+		req, err := http.NewRequest(method, "http://0.0.0.0:8080"+path, body)
+		require.NoError(t, err)
+
+		resp, err := otherClient.Do(req)
+		require.NoError(t, err, "Error sending the %s request to %s", method, path)
+		defer resp.Body.Close()
+
+		return resp
+	}
+
+	resp := other(http.MethodGet, "/images/"+id+"/meta", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "A private image is shown to another user")
+
+	resp = imageRequest(t, http.MethodPut, "/images/"+id+"/grants/other", strings.NewReader(`{"permission": "read"}`))
+	require.Equal(t, http.StatusOK, resp.StatusCode, "Sharing the image failed")
+
+	resp = other(http.MethodGet, "/images/"+id+"/meta", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "A shared image isn't shown")
+
+	resp = other(http.MethodDelete, "/images/"+id, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "An image shared for reading is deleted")
+
+	resp = other(http.MethodPatch, "/images/"+id, strings.NewReader(`{"visibility": "public"}`))
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Only the owner changes the visibility")
+
+	resp = imageRequest(t, http.MethodPatch, "/images/"+id, strings.NewReader(`{"visibility": "bogus"}`))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = imageRequest(t, http.MethodDelete, "/images/"+id+"/grants/other", nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode, "Revoking the grant failed")
+
+	resp = imageRequest(t, http.MethodPatch, "/images/"+id, strings.NewReader(`{"visibility": "unlisted"}`))
+	require.Equal(t, http.StatusOK, resp.StatusCode, "Changing the visibility failed")
+
+	resp = other(http.MethodGet, "/images/"+id+"/meta", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "An unlisted image isn't shown by its id")
+}
+
+func TestShareLink(t *testing.T) {
+	id := saveImage(t, "linkTest.png", pngContent("this image is shared with a link"))
+	createLink := func(body string) (link struct{ ID, URL string }) {
+		req, err := http.NewRequest(http.MethodPost, "http://0.0.0.0:8080/images/"+id+"/links", strings.NewReader(body))
+		require.NoError(t, err)
+
+		resp, err := client.Do(req)
+		require.NoError(t, err, "Error creating a share link")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&link), "Error decoding the link")
+		return link
+	}
+	// links are used without credentials, headers are given as names followed by values
+	var etag string
+	get := func(path string, header ...string) int {
+		req, err := http.NewRequest(http.MethodGet, "http://0.0.0.0:8080"+path, nil)
+		require.NoError(t, err)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
+			etag = resp.Header.Get("ETag")
+		}
+		return resp.StatusCode
+	}
+
+	link := createLink(`{"ttl": "1h", "max_downloads": 1, "renditions": ["original"]}`)
+	// only full responses are downloads, a browser showing the image asks for ranges and revalidates
+	assert.Equal(t, http.StatusPartialContent, get(link.URL, "Range", "bytes=0-9"), "The range isn't served with the link")
+	assert.Equal(t, http.StatusPartialContent, get(link.URL, "Range", "bytes=10-"), "The range is counted as a download")
+	require.NotEmpty(t, etag, "The image has no ETag")
+	assert.Equal(t, http.StatusNotModified, get(link.URL, "If-None-Match", etag), "The unchanged image is counted as a download")
+	assert.Equal(t, http.StatusOK, get(link.URL), "The image isn't served with the link")
+	assert.Equal(t, http.StatusGone, get(link.URL, "Range", "bytes=0-9"), "A range is served with a used up link")
+	assert.Equal(t, http.StatusGone, get(link.URL), "The link is used more times than allowed")
+	assert.Equal(t, http.StatusForbidden, get(link.URL+"/thumb"), "The link serves a rendition it doesn't allow")
+	assert.Equal(t, http.StatusNotFound, get(link.URL+"x"), "A tampered link is accepted")
+
+	link = createLink(`{}`)
+	resp := imageRequest(t, http.MethodDelete, "/images/"+id+"/links/"+link.ID, nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode, "Revoking the link failed")
+	assert.Equal(t, http.StatusGone, get(link.URL), "A revoked link is accepted")
+}
+
+func TestUsage(t *testing.T) {
+	usage := func() (usage struct{ Bytes, Objects int64 }) {
+		req, err := http.NewRequest(http.MethodGet, "http://0.0.0.0:8080"+handlers.USAGE_PATH, nil)
+		require.NoError(t, err)
+
+		resp, err := client.Do(req)
+		require.NoError(t, err, "Error getting the usage")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&usage), "Error decoding the usage")
+		return usage
+	}
+
+	before := usage()
+	content := pngContent("this image is counted in the usage")
+	id := saveImage(t, "usageTest.png", content)
+
+	after := usage()
+	assert.Equal(t, before.Objects+1, after.Objects, "The image isn't counted")
+	assert.Greater(t, after.Bytes, before.Bytes, "Bytes of the image aren't counted")
+
+	resp := imageRequest(t, http.MethodDelete, "/images/"+id, nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, before, usage(), "The deleted image is still counted")
+
+	resp = imageRequest(t, http.MethodGet, handlers.USAGE_REPORT_PATH, nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "The usage report is shown to a user who isn't an admin")
+}
+
+// metricValue sums the samples of the metric with the labels given
+func metricValue(t *testing.T, name string, labels map[string]string) (value float64) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err, "Error gathering the metrics")
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+	samples:
+		for _, metric := range family.GetMetric() {
+			for _, pair := range metric.GetLabel() {
+				if want, ok := labels[pair.GetName()]; ok && want != pair.GetValue() {
+					continue samples
+				}
+			}
+			value += metric.GetCounter().GetValue() + metric.GetGauge().GetValue()
+		}
+	}
+
+	return value
+}
+
+func TestMetrics(t *testing.T) {
+	before := metricValue(t, "testapp_upload_bytes_total", map[string]string{"kind": services.UPLOAD_KIND_IMAGE})
+	content := pngContent("this image is counted in the metrics")
+	saveImage(t, "metricsTest.png", content)
+	after := metricValue(t, "testapp_upload_bytes_total", map[string]string{"kind": services.UPLOAD_KIND_IMAGE})
+	assert.GreaterOrEqual(t, after-before, float64(len(content)), "Bytes of the upload aren't counted")
+
+	resp := imageRequest(t, http.MethodGet, handlers.USAGE_PATH, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// scrapers don't send credentials
+	resp, err := http.Get("http://0.0.0.0:8080" + metrics.DEFAULT_PATH)
+	require.NoError(t, err, "Error scraping the metrics")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	for _, series := range []string{
+		`testapp_http_requests_total{code="200",method="GET",route="` + handlers.USAGE_PATH + `"}`,
+		`testapp_http_request_duration_seconds_bucket{method="GET",route="` + handlers.USAGE_PATH + `"`,
+		`testapp_db_query_duration_seconds_count{operation="create",table="images"}`,
+		`testapp_images{state="live"}`,
+		`testapp_database_size_bytes`,
+		`go_sql_open_connections`,
+	} {
+		assert.Contains(t, string(body), series, "%s isn't exported", series)
+	}
+
+	// requests are labeled with their route, not their path
+	id := uuid.NewString()
+	resp = imageRequest(t, http.MethodGet, "/images/"+id+"/meta", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	route := map[string]string{"route": handlers.META_PATH, "code": "404"}
+	assert.Positive(t, metricValue(t, "testapp_http_requests_total", route), "The request isn't counted under its route")
+	assert.Zero(t, metricValue(t, "testapp_http_requests_total", map[string]string{"route": "/images/" + id + "/meta"}),
+		"The request is labeled with its path")
+}
+
+func TestTracing(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("myfiles", "tracingTest.png")
+	require.NoError(t, err)
+	part.Write(pngContent("this image is traced"))
+	require.NoError(t, writer.Close())
+
+	req, err := http.NewRequest(http.MethodPost, "http://0.0.0.0:8080"+handlers.SAVE_DB_PATH, body)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+
+	resp, err := client.Do(req)
+	require.NoError(t, err, "Error saving the image")
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the server span ends after the response is sent
+	var traced tracetest.SpanStubs
+	require.Eventually(t, func() bool {
+		traced = nil
+		for _, span := range spans.GetSpans() {
+			if span.SpanContext.TraceID().String() == traceID {
+				traced = append(traced, span)
+			}
+		}
+		return slices.ContainsFunc(traced, func(span tracetest.SpanStub) bool { return span.Name == "POST "+handlers.SAVE_DB_PATH })
+	}, time.Second, 10*time.Millisecond, "The request isn't traced as part of the client's trace")
+
+	names := []string{}
+	var insert string
+	for _, span := range traced {
+		names = append(names, span.Name)
+		for _, attr := range span.Attributes {
+			if span.Name == "create images" && attr.Key == "db.query.text" {
+				insert = attr.Value.AsString()
+			}
+		}
+	}
+	for _, name := range []string{"multipart.NextPart", "Batch.Add", "ImageService.write", "create images"} {
+		assert.Contains(t, names, name, "The request has no %s span", name)
+	}
+	assert.Contains(t, insert, `INSERT INTO "images"`, "The statement isn't recorded")
+}
+
+func TestDeleteImages(t *testing.T) {
+	id := saveImage(t, "batchDeleteTest.png", pngContent("this image is deleted in a batch"))
+	missing := "00000000-0000-0000-0000-000000000000"
+
+	body := fmt.Sprintf(`{"ids": [%q, %q]}`, id, missing)
+	req, err := http.NewRequest(http.MethodDelete, "http://0.0.0.0:8080"+handlers.IMAGES_PATH, strings.NewReader(body))
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err, "Error sending the batch delete request")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result struct {
+		Deleted  []string `json:"deleted"`
+		NotFound []string `json:"not_found"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result), "Error decoding the batch result")
+	assert.Equal(t, []string{id}, result.Deleted)
+	assert.Equal(t, []string{missing}, result.NotFound)
+}
+
+func TestShowCaching(t *testing.T) {
+	content := pngContent("this image is cached and served in ranges")
+	id := saveImage(t, "cacheTest.png", content)
+	path := "/show/" + id
+
+	get := func(headers map[string]string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, "http://0.0.0.0:8080"+path, nil)
+		require.NoError(t, err)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+
+		resp, err := client.Do(req)
+		require.NoError(t, err, "Error sending the GET request to %s", path)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err, "Error reading the resp.Body")
+
+		return resp, body
+	}
+
+	resp, _ := get(nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	require.NotEmpty(t, etag, "No ETag is sent")
+	assert.NotEmpty(t, resp.Header.Get("Last-Modified"))
+	assert.Equal(t, "private", resp.Header.Get("Cache-Control"), "An image not everyone sees is kept by shared caches")
+	assert.Contains(t, resp.Header.Get("Vary"), "Authorization")
+
+	resp, body := get(map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode, "A fresh copy is sent again")
+	assert.Empty(t, body)
+
+	resp, body = get(map[string]string{"Range": "bytes=0-7"})
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, content[:8], body)
+	assert.Equal(t, fmt.Sprintf("bytes 0-7/%d", len(content)), resp.Header.Get("Content-Range"))
+
+	resp, body = get(map[string]string{"Range": "bytes=0-7", "If-Range": `"stale"`})
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "A range of changed content is sent")
+	assert.Equal(t, content, body)
+
+	resp = imageRequest(t, http.MethodPatch, "/images/"+id, strings.NewReader(`{"visibility": "public"}`))
+	require.Equal(t, http.StatusOK, resp.StatusCode, "Changing the visibility failed")
+
+	resp, _ = get(nil)
+	assert.Contains(t, resp.Header.Get("Cache-Control"), "public", "A public image isn't kept by shared caches")
+}
+
+// imageContent is a width x height PNG, its pixels differ so images don't share their content
+func imageContent(width, height int) []byte {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	if _, err := rand.Read(img.Pix); err != nil {
+		panic(err)
+	}
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		panic(err)
+	}
+	return encoded.Bytes()
+}
+
+func TestShowTransform(t *testing.T) {
+	id := saveImage(t, "transformTest.png", imageContent(40, 20))
+
+	get := func(query string) (*http.Response, []byte) {
+		resp, err := client.Get("http://0.0.0.0:8080/show/" + id + "?" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, body
+	}
+
+	tests := []struct {
+		query       string
+		contentType string
+		format      string
+		size        image.Point
+	}{
+		{query: "w=10", contentType: "image/png", format: "png", size: image.Pt(10, 5)},
+		{query: "h=10&format=jpeg&q=50", contentType: "image/jpeg", format: "jpeg", size: image.Pt(20, 10)},
+		{query: "w=8&h=8&fit=cover&format=webp", contentType: "image/webp", format: "webp", size: image.Pt(8, 8)},
+		{query: "w=8&h=8&fit=contain&format=gif", contentType: "image/gif", format: "gif", size: image.Pt(8, 4)},
+		{query: "w=10&rotate=90", contentType: "image/png", format: "png", size: image.Pt(10, 20)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			resp, body := get(test.query)
+			require.Equal(t, http.StatusOK, resp.StatusCode, "The image isn't transformed: %s", body)
+			assert.Equal(t, test.contentType, resp.Header.Get("Content-Type"))
+
+			config, format, err := image.DecodeConfig(bytes.NewReader(body))
+			require.NoError(t, err)
+			assert.Equal(t, test.format, format)
+			assert.Equal(t, test.size, image.Pt(config.Width, config.Height))
+		})
+	}
+
+	// variants are stored once per normalized options and read from the cache afterwards
+	variants, err := os.ReadDir(filepath.Join(cacheDir, services.VARIANTS_PREFIX, id))
+	require.NoError(t, err, "No variant is cached")
+	assert.Len(t, variants, len(tests))
+
+	first, firstBody := get("w=10&format=png")
+	again, againBody := get("w=10")
+	require.Equal(t, http.StatusOK, again.StatusCode)
+	assert.Equal(t, firstBody, againBody, "An equivalent request isn't the cached variant")
+	assert.Equal(t, first.Header.Get("ETag"), again.Header.Get("ETag"))
+
+	variants, err = os.ReadDir(filepath.Join(cacheDir, services.VARIANTS_PREFIX, id))
+	require.NoError(t, err)
+	assert.Len(t, variants, len(tests), "An equivalent request is cached again")
+
+	resp, _ := get("format=webp&q=80")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "A quality for webp is accepted")
+}
+
+func TestShowTransformTooLarge(t *testing.T) {
+	// 8192 wide it would be 8192x3276800, more than the server can allocate
+	id := saveImage(t, "narrowTest.png", imageContent(1, 400))
+
+	resp := imageRequest(t, http.MethodGet, "/show/"+id+"?w=8192", nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode, "A transformation too large for the image isn't refused")
+
+	resp = imageRequest(t, http.MethodGet, "/show/"+id+"?h=800", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "The server is down after a transformation too large")
+}
+
+// renditionServer serves images with renditions of its own, they stay pending until the test runs them
+func renditionServer(t *testing.T, renditions []imaging.Rendition) (*services.RenditionService, string) {
+	renditionServ := services.NewRenditionService(testImageRep, testImages, renditions, 1)
+	imageHandler := handlers.NewImageHandler(testImages, testTransforms, renditionServ, pkgHTTP.Config{})
+
+	server := httptest.NewServer(pkgHTTP.NewServer(pkgHTTP.Config{}, testAuth, imageHandler).Handler)
+	t.Cleanup(server.Close)
+
+	return renditionServ, server.URL
+}
+
+func TestRenditions(t *testing.T) {
+	renditions, serverURL := renditionServer(t, []imaging.Rendition{
+		{Name: "square", Width: 8, Height: 8, Fit: imaging.FIT_COVER},
+		// 1024 wide the narrow image would be 1024x307200, too large to be generated
+		{Name: "wide", Width: 1024, Format: imaging.JPEG},
+	})
+
+	id := saveImage(t, "renditionTest.png", imageContent(2, 600))
+	stored, err := testImageRep.Get(context.Background(), uuid.MustParse(id))
+	require.NoError(t, err)
+	require.NoError(t, renditions.Enqueue(context.Background(), stored))
+
+	get := func(client *http.Client, path string) (*http.Response, []byte) {
+		resp, err := client.Get(serverURL + path)
+		require.NoError(t, err, "Error sending the GET request to %s", path)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, body
+	}
+	statuses := func() map[string]string {
+		resp, body := get(client, "/images/"+id+"/renditions")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var listed []models.Image
+		require.NoError(t, json.Unmarshal(body, &listed))
+		statuses := make(map[string]string)
+		for _, rendition := range listed {
+			assert.Equal(t, id, rendition.ParentID.String())
+			statuses[rendition.Rendition] = rendition.Status
+		}
+		return statuses
+	}
+
+	resp, _ := get(client, "/show/"+id+"/square")
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode, "A pending rendition is served")
+	assert.Equal(t, models.STATUS_PENDING, resp.Header.Get("Rendition-Status"))
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+	listed := statuses()
+	assert.Equal(t, models.STATUS_PENDING, listed["square"])
+	assert.Equal(t, models.STATUS_PENDING, listed["wide"])
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go renditions.Run(ctx, time.Minute)
+
+	require.Eventually(t, func() bool {
+		listed := statuses()
+		return listed["square"] != models.STATUS_PENDING && listed["wide"] != models.STATUS_PENDING
+	}, 10*time.Second, 50*time.Millisecond, "The renditions aren't generated")
+	listed = statuses()
+	assert.Equal(t, models.STATUS_READY, listed["square"])
+	assert.Equal(t, models.STATUS_FAILED, listed["wide"], "A rendition too large for the image isn't failed")
+
+	resp, body := get(client, "/show/"+id+"/square")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, models.STATUS_READY, resp.Header.Get("Rendition-Status"))
+	assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+	config, _, err := image.DecodeConfig(bytes.NewReader(body))
+	require.NoError(t, err)
+	assert.Equal(t, image.Pt(8, 8), image.Pt(config.Width, config.Height))
+
+	resp, _ = get(client, "/show/"+id+"/wide")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "A failed rendition is served")
+	assert.Equal(t, models.STATUS_FAILED, resp.Header.Get("Rendition-Status"))
+
+	resp, _ = get(client, "/show/"+id+"/poster")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "An unknown rendition is served")
+	assert.Empty(t, resp.Header.Get("Rendition-Status"))
+
+	resp, _ = get(otherClient, "/show/"+id+"/square")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "A rendition of a private image is shown to others")
+	resp, _ = get(otherClient, "/images/"+id+"/renditions")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Renditions of a private image are listed to others")
+}
+
 func endpointBenchmark(b *testing.B, endpoint string) {
 	u := fmt.Sprintf("http://localhost:8080%s", endpoint)
 	for i := 0; i < b.N; i++ {